@@ -2,46 +2,219 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/radudd/vault-bootstrap/internal/bootstrap"
 	log "github.com/sirupsen/logrus"
 )
 
-func main() {
+// version is set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
+type command struct {
+	name        string
+	description string
+	flags       func(fs *flag.FlagSet) func() int
+}
 
-	/*
-		if os.Args[1] == "job" || len(os.Args) == 0 {
+// Each command registers its flags and returns the function running it
+var commands = []command{
+	{"job", "initialize, unseal and configure Vault (default)", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddInitFlags(fs)
+		bootstrap.AddConfigureFlags(fs)
+		return func() int {
 			log.Info("Running in job mode...")
 			bootstrap.Run()
-		} else if os.Args[1] == "init-container" {
+			return 0
+		}
+	}},
+	{"init", "initialize Vault and store the root token and the unseal keys", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddInitFlags(fs)
+		return func() int {
+			bootstrap.RunInit()
+			return 0
+		}
+	}},
+	{"unseal", "unseal the Vault cluster members using the stored unseal keys", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		return func() int {
+			bootstrap.RunUnseal()
+			return 0
+		}
+	}},
+	{"rekey", "replace the stored unseal keys, optionally changing the key shares and threshold", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		shares := fs.Int("new-key-shares", 0, "key shares of the new unseal keys. Defaults to the current ones")
+		threshold := fs.Int("new-key-threshold", 0, "key threshold of the new unseal keys. Defaults to the current one")
+		pgpKeys := fs.String("pgp-keys", "", "comma separated PGP public keys (base64 or keybase:<user>) encrypting the new unseal keys")
+		confirm := fs.Bool("confirm", false, "confirm a verified rekey and delete the backup of the previous unseal keys")
+		return func() int { return bootstrap.Rekey(*shares, *threshold, *pgpKeys, *confirm) }
+	}},
+	{"generate-root", "generate a new root token using the stored unseal keys", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddConfigureFlags(fs)
+		store := fs.Bool("store", false, "store the root token in the root token secret instead of printing it")
+		ttl := fs.Duration("ttl", time.Hour, "TTL in Vault of the stored root token")
+		configure := fs.Bool("configure", false, "run the configuration steps with the root token, then revoke it")
+		return func() int { return bootstrap.GenerateRoot(*store, *ttl, *configure) }
+	}},
+	{"status", "report the init, seal, HA and Raft state of the Vault cluster members", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddConfigureFlags(fs)
+		output := fs.String("output", "text", "output: text or json")
+		fs.StringVar(output, "o", "text", "shorthand for --output")
+		return func() int { return bootstrap.Status(*output) }
+	}},
+	{"configure", "configure an unsealed Vault", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddConfigureFlags(fs)
+		return func() int {
+			bootstrap.RunConfigure()
+			return 0
+		}
+	}},
+	{"init-container", "start the unseal Job of the Vault pod", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddInitContainerFlags(fs)
+		return func() int {
 			log.Info("Running in init-container mode...")
-			bootstrap.Init()
-		} else {
-			panic("First argument(running mode) must be 'job' or 'init-container'")
+			bootstrap.InitContainer()
+			return 0
+		}
+	}},
+	{"plan", "print the changes needed to reach the desired state", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddStateFlags(fs)
+		output := fs.String("output", "text", "output: text or json")
+		return func() int { return bootstrap.Plan(*output) }
+	}},
+	{"apply", "apply the changes needed to reach the desired state", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddStateFlags(fs)
+		output := fs.String("output", "text", "output: text or json")
+		return func() int { return bootstrap.Apply(*output) }
+	}},
+	{"export", "write the current Vault configuration as a desired state document", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		file := fs.String("file", "", "file written by export. Defaults to stdout")
+		return func() int { return bootstrap.Export(*file) }
+	}},
+	{"drift", "report the differences between Vault and the desired state", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddStateFlags(fs)
+		bootstrap.AddDriftFlags(fs)
+		output := fs.String("output", "text", "output: text or json")
+		return func() int { return bootstrap.Drift(*output) }
+	}},
+	{"validate", "validate the configuration file, the environment variables and the flags", func(fs *flag.FlagSet) func() int {
+		bootstrap.AddVaultFlags(fs)
+		bootstrap.AddInitFlags(fs)
+		bootstrap.AddConfigureFlags(fs)
+		bootstrap.AddStateFlags(fs)
+		bootstrap.AddDriftFlags(fs)
+		return func() int {
+			log.Info("Configuration is valid")
+			return 0
+		}
+	}},
+	{"version", "print the version", func(fs *flag.FlagSet) func() int {
+		return func() int {
+			fmt.Printf("vault-bootstrap %s (%s)\n", version, runtime.Version())
+			return 0
+		}
+	}},
+}
+
+func main() {
+	args := os.Args[1:]
+
+	// Without a command, run the Job as before the command tree existed
+	name := "job"
+	if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+		usage()
+		os.Exit(0)
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	} else if mode, rest, ok := deprecatedMode(args); ok {
+		log.Warnf("--mode is deprecated. Use 'vault-bootstrap %s' instead", mode)
+		name, args = mode, rest
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		run := cmd.flags(fs)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: vault-bootstrap %s [flags]\n\n%s\n\nFlags:\n", cmd.name, cmd.description)
+			fs.PrintDefaults()
 		}
-		//runningMode := flag.String("mode", "job", "running mode: job or init-container")
-		httpCheckUrl := flag.String("http-check", "http://www.redhat.com", "http check mode")
-		flag.Parse()
-		flag.Visit(func(f *flag.Flag){
-			if f.Name == "http-check" {
-				httpCheck.Check(*httpCheckUrl)
+		fs.Parse(args)
+		if fs.NArg() > 0 {
+			fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+			fs.Usage()
+			os.Exit(2)
+		}
+		// Keep stdout parseable when it carries JSON or the exported document
+		if f := fs.Lookup("output"); f != nil && f.Value.String() == "json" {
+			log.SetOutput(os.Stderr)
+		}
+		if f := fs.Lookup("file"); f != nil && f.Value.String() == "" {
+			log.SetOutput(os.Stderr)
+		}
+		// generate-root may print the token
+		if cmd.name == "generate-root" {
+			log.SetOutput(os.Stderr)
+		}
+		log.Info("LogLevel set to " + log.GetLevel().String())
+		log.Info(runtime.Version())
+
+		// Settings are resolved and validated before any action
+		if cmd.name != "version" {
+			if err := bootstrap.LoadSettings(); err != nil {
+				log.Error(err.Error())
+				os.Exit(1)
 			}
-		})
-	*/
-	runningMode := flag.String("mode", "job", "running mode: job or init-container")
-	flag.Parse()
-	if *runningMode == "job" {
-		log.Info("Running in job mode...")
-		bootstrap.Run()
-	} else if *runningMode == "init-container" {
-		log.Info("Running in init-container mode...")
-		bootstrap.InitContainer()
-	} else {
-		panic("Running mode must be 'sidecar' or 'job'")
+		}
+		code := run()
+		// Close the port-forwards to the Vault pods, if any
+		bootstrap.Close()
+		os.Exit(code)
 	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// deprecatedMode extracts the command from the --mode flag used before the command tree existed
+func deprecatedMode(args []string) (string, []string, bool) {
+	for i, arg := range args {
+		for _, prefix := range []string{"-mode", "--mode"} {
+			if arg == prefix && i+1 < len(args) {
+				return args[i+1], append(append([]string{}, args[:i]...), args[i+2:]...), true
+			}
+			if strings.HasPrefix(arg, prefix+"=") {
+				return strings.TrimPrefix(arg, prefix+"="), append(append([]string{}, args[:i]...), args[i+1:]...), true
+			}
+		}
+	}
+	return "", args, false
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: vault-bootstrap <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s%s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'vault-bootstrap <command> -h' for the flags of a command\n")
 }
 
 func init() {
@@ -62,7 +235,4 @@ func init() {
 
 	// set level
 	log.SetLevel(level)
-	log.Info("LogLevel set to " + level.String())
-
-	log.Info(runtime.Version())
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeprecatedMode(t *testing.T) {
+	tests := []struct {
+		args []string
+		mode string
+		rest []string
+		ok   bool
+	}{
+		{[]string{"--mode", "unseal"}, "unseal", []string{}, true},
+		{[]string{"-mode=init", "--key-shares", "3"}, "init", []string{"--key-shares", "3"}, true},
+		{[]string{"--vault-addr", "http://vault:8200", "--mode=job"}, "job", []string{"--vault-addr", "http://vault:8200"}, true},
+		{[]string{"--vault-addr", "http://vault:8200", "-mode", "configure", "--pki"}, "configure", []string{"--vault-addr", "http://vault:8200", "--pki"}, true},
+		{[]string{"--mode"}, "", []string{"--mode"}, false},
+		{[]string{"--vault-addr", "http://vault:8200"}, "", []string{"--vault-addr", "http://vault:8200"}, false},
+		{[]string{}, "", []string{}, false},
+	}
+	for _, test := range tests {
+		mode, rest, ok := deprecatedMode(test.args)
+		if mode != test.mode || ok != test.ok || !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("deprecatedMode(%q) = %q, %q, %v, want %q, %q, %v", test.args, mode, rest, ok, test.mode, test.rest, test.ok)
+		}
+	}
+}
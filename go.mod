@@ -4,9 +4,12 @@ go 1.14
 
 require (
 	github.com/google/uuid v1.1.1
+	github.com/hashicorp/hcl v1.0.0
 	github.com/hashicorp/vault/api v1.0.4
 	github.com/sirupsen/logrus v1.6.0
+	gopkg.in/square/go-jose.v2 v2.3.1
 	k8s.io/api v0.18.6
 	k8s.io/apimachinery v0.18.6
 	k8s.io/client-go v0.18.6
+	sigs.k8s.io/yaml v1.2.0
 )
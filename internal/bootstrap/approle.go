@@ -0,0 +1,144 @@
+package bootstrap
+
+import (
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const DefaultAppRolePath = "approle"
+
+type appRoleConfig struct {
+	Path  string          `json:"path"`
+	Tune  authMountConfig `json:"tune"`
+	Roles []appRoleRole   `json:"roles"`
+}
+
+type appRoleRole struct {
+	Name               string   `json:"name"`
+	Policies           []string `json:"policies"`
+	TokenTTL           string   `json:"tokenTTL"`
+	TokenMaxTTL        string   `json:"tokenMaxTTL"`
+	TokenBoundCIDRs    []string `json:"tokenBoundCIDRs"`
+	SecretIDTTL        string   `json:"secretIDTTL"`
+	SecretIDNumUses    int      `json:"secretIDNumUses"`
+	SecretIDBoundCIDRs []string `json:"secretIDBoundCIDRs"`
+	// K8s secret where role_id and secret_id are saved. Defaults to approle-<name>
+	Secret string `json:"secret"`
+	// If set, the secret_id is response-wrapped and only the wrapping token is saved
+	WrapTTL string `json:"wrapTTL"`
+	// Generate a new secret_id even if one was already saved in the K8s secret
+	RegenerateSecretID bool `json:"regenerateSecretID"`
+}
+
+func configureAppRole(client *vault.Client, clientsetK8s *kubernetes.Clientset, config appRoleConfig) error {
+	path := config.Path
+	if path == "" {
+		path = DefaultAppRolePath
+	}
+	if err := enableAuth(client, path, "approle", config.Tune); err != nil {
+		return fmt.Errorf("AppRole authentication: %s", err.Error())
+	}
+	for _, role := range config.Roles {
+		if err := configureAppRoleRole(client, clientsetK8s, path, role); err != nil {
+			return fmt.Errorf("AppRole authentication: role %s: %s", role.Name, err.Error())
+		}
+	}
+	log.Info("AppRole authentication: Successfully configured")
+	return nil
+}
+
+func configureAppRoleRole(client *vault.Client, clientsetK8s *kubernetes.Clientset, path string, role appRoleRole) error {
+	if role.Name == "" {
+		return fmt.Errorf("name is mandatory")
+	}
+	rolePath := fmt.Sprintf("auth/%s/role/%s", path, role.Name)
+
+	data := map[string]interface{}{
+		"token_policies":        role.Policies,
+		"token_ttl":             role.TokenTTL,
+		"token_max_ttl":         role.TokenMaxTTL,
+		"token_bound_cidrs":     role.TokenBoundCIDRs,
+		"secret_id_ttl":         role.SecretIDTTL,
+		"secret_id_num_uses":    role.SecretIDNumUses,
+		"secret_id_bound_cidrs": role.SecretIDBoundCIDRs,
+	}
+	if _, err := client.Logical().Write(rolePath, data); err != nil {
+		return err
+	}
+	log.Infof("AppRole authentication: Role %s configured", role.Name)
+
+	roleIDResp, err := client.Logical().Read(rolePath + "/role-id")
+	if err != nil {
+		return err
+	}
+	if roleIDResp == nil || roleIDResp.Data["role_id"] == nil {
+		return fmt.Errorf("cannot read role-id")
+	}
+	roleID := roleIDResp.Data["role_id"].(string)
+
+	secretName := role.Secret
+	if secretName == "" {
+		secretName = "approle-" + role.Name
+	}
+
+	// Keep the secret_id which was already delivered, unless explicitly asked to regenerate it
+	secret, err := getK8sSecret(clientsetK8s, secretName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && !role.RegenerateSecretID {
+		_, hasSecretID := secret.Data["secret_id"]
+		_, hasWrappingToken := secret.Data["wrapping_token"]
+		if (hasSecretID || hasWrappingToken) && string(secret.Data["role_id"]) == roleID {
+			log.Infof("AppRole authentication: Secret ID for role %s already delivered in K8s secret %s", role.Name, secretName)
+			return nil
+		}
+	}
+
+	secretData, err := generateAppRoleSecretID(client, rolePath, role.WrapTTL)
+	if err != nil {
+		return err
+	}
+	secretData["role_id"] = roleID
+	return applyK8sSecret(clientsetK8s, secretName, secretData)
+}
+
+// Generate a new secret_id. When wrapTTL is set, only the wrapping token is returned
+func generateAppRoleSecretID(client *vault.Client, rolePath string, wrapTTL string) (map[string]string, error) {
+	if wrapTTL == "" {
+		resp, err := client.Logical().Write(rolePath+"/secret-id", nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil || resp.Data["secret_id"] == nil {
+			return nil, fmt.Errorf("cannot generate secret-id")
+		}
+		return map[string]string{
+			"secret_id": resp.Data["secret_id"].(string),
+		}, nil
+	}
+
+	// Wrapping function is not copied by Clone, so set it only on the copy
+	wrapClient, err := client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	wrapClient.SetToken(client.Token())
+	wrapClient.SetWrappingLookupFunc(func(operation, path string) string {
+		return wrapTTL
+	})
+	resp, err := wrapClient.Logical().Write(rolePath+"/secret-id", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.WrapInfo == nil {
+		return nil, fmt.Errorf("cannot generate wrapped secret-id")
+	}
+	return map[string]string{
+		"wrapping_token": resp.WrapInfo.Token,
+	}, nil
+}
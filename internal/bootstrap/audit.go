@@ -0,0 +1,92 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+type auditDeviceConfig struct {
+	// Defaults to the type
+	Path        string `json:"path,omitempty"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	// Type specific options, e.g. file_path and mode for file, address and socket_type for socket,
+	// facility and tag for syslog
+	Options map[string]string `json:"options,omitempty"`
+	Local   bool              `json:"local,omitempty"`
+}
+
+func configureAuditDevices(client *vault.Client, config []auditDeviceConfig) error {
+	audits, err := client.Sys().ListAudit()
+	if err != nil {
+		return fmt.Errorf("Audit devices: %s", err.Error())
+	}
+	var paths []string
+	for _, device := range config {
+		path, err := enableAuditDevice(client, audits, device)
+		if err != nil {
+			return fmt.Errorf("Audit devices: %s", err.Error())
+		}
+		paths = append(paths, path)
+	}
+	if err := checkAuditDevices(client, paths); err != nil {
+		return fmt.Errorf("Audit devices: %s", err.Error())
+	}
+	log.Info("Audit devices: Successfully configured")
+	return nil
+}
+
+// enableAuditDevice enables the device unless already present and returns its path
+// audits holds the current devices, as returned by Sys().ListAudit()
+func enableAuditDevice(client *vault.Client, audits map[string]*vault.Audit, device auditDeviceConfig) (string, error) {
+	if device.Type == "" {
+		return "", fmt.Errorf("type is mandatory")
+	}
+	path := strings.Trim(device.Path, "/")
+	if path == "" {
+		path = device.Type
+	}
+
+	if current, ok := audits[path+"/"]; ok {
+		if current.Type != device.Type {
+			return "", fmt.Errorf("%s is already enabled with type %s instead of %s", path, current.Type, device.Type)
+		}
+		for k, v := range device.Options {
+			if current.Options[k] != v {
+				// Audit devices cannot be tuned and re-enabling one resets its HMAC salt
+				log.Warnf("Audit devices: %s already enabled with %s=%s instead of %s. Disable it manually to apply the configuration", path, k, current.Options[k], v)
+			}
+		}
+		log.Infof("Audit devices: %s already enabled", path)
+		return path, nil
+	}
+
+	if err := client.Sys().EnableAuditWithOptions(path, &vault.EnableAuditOptions{
+		Type:        device.Type,
+		Description: device.Description,
+		Options:     device.Options,
+		Local:       device.Local,
+	}); err != nil {
+		return "", err
+	}
+	log.Infof("Audit devices: %s enabled at %s", device.Type, path)
+	return path, nil
+}
+
+// checkAuditDevices performs harmless requests to confirm the audit devices do not block Vault
+// Vault refuses to serve requests which cannot be logged by at least one audit device
+func checkAuditDevices(client *vault.Client, paths []string) error {
+	if _, err := client.Auth().Token().LookupSelf(); err != nil {
+		return fmt.Errorf("Vault requests are failing after enabling the audit devices - %s", err.Error())
+	}
+	for _, path := range paths {
+		if _, err := client.Sys().AuditHash(path, "vault-bootstrap"); err != nil {
+			return fmt.Errorf("%s is not working - %s", path, err.Error())
+		}
+		log.Debugf("Audit devices: %s checked", path)
+	}
+	return nil
+}
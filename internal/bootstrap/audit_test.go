@@ -0,0 +1,188 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// fakeAuditVault serves the audit endpoints used by configureAuditDevices. Like Vault, it writes
+// every request to the file of each enabled file device and fails the requests it can't log
+type fakeAuditVault struct {
+	mu      sync.Mutex
+	devices map[string]*vault.Audit
+	files   map[string]*os.File
+	enabled int
+}
+
+func newFakeAuditVault(t *testing.T) (*fakeAuditVault, *vault.Client) {
+	fake := &fakeAuditVault{devices: map[string]*vault.Audit{}, files: map[string]*os.File{}}
+	client := newFakeVaultClient(t, fake)
+	t.Cleanup(func() {
+		for _, f := range fake.files {
+			f.Close()
+		}
+	})
+	return fake, client
+}
+
+// newFakeVaultClient returns a client of a fake Vault served by handler
+func newFakeVaultClient(t *testing.T, handler http.Handler) *vault.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config := vault.DefaultConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	client, err := vault.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("root")
+	return client
+}
+
+func (f *fakeAuditVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for path, file := range f.files {
+		if _, err := fmt.Fprintf(file, "{\"type\":\"request\",\"path\":%q}\n", r.URL.Path); err != nil {
+			http.Error(w, fmt.Sprintf(`{"errors":["failed to log request with %s"]}`, path), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/sys/audit":
+		writeFakeData(w, f.devices)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/sys/audit/"):
+		path := strings.TrimPrefix(r.URL.Path, "/v1/sys/audit/") + "/"
+		var options vault.EnableAuditOptions
+		if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := f.devices[path]; ok {
+			http.Error(w, `{"errors":["path already in use"]}`, http.StatusBadRequest)
+			return
+		}
+		if options.Type == "file" {
+			file, err := os.OpenFile(options.Options["file_path"], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"errors":[%q]}`, err.Error()), http.StatusBadRequest)
+				return
+			}
+			f.files[path] = file
+		}
+		f.devices[path] = &vault.Audit{Type: options.Type, Path: path, Options: options.Options}
+		f.enabled++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/sys/audit/"):
+		path := strings.TrimPrefix(r.URL.Path, "/v1/sys/audit/") + "/"
+		if file, ok := f.files[path]; ok {
+			file.Close()
+			delete(f.files, path)
+		}
+		delete(f.devices, path)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/auth/token/lookup-self":
+		writeFakeData(w, map[string]interface{}{"id": "root", "policies": []string{"root"}})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/sys/audit-hash/"):
+		path := strings.TrimPrefix(r.URL.Path, "/v1/sys/audit-hash/") + "/"
+		if _, ok := f.devices[path]; !ok {
+			http.Error(w, `{"errors":["unknown audit backend"]}`, http.StatusBadRequest)
+			return
+		}
+		writeFakeData(w, map[string]interface{}{"hash": "hmac-sha256:0123"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeAuditVault) enables() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.enabled
+}
+
+func tempAuditFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "audit.log")
+}
+
+func writeFakeData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func TestConfigureAuditDevicesFile(t *testing.T) {
+	fake, client := newFakeAuditVault(t)
+	auditFile := tempAuditFile(t)
+	config := []auditDeviceConfig{{Type: "file", Options: map[string]string{"file_path": auditFile}}}
+
+	if err := configureAuditDevices(client, config); err != nil {
+		t.Fatalf("configureAuditDevices: %s", err)
+	}
+	if n := fake.enables(); n != 1 {
+		t.Fatalf("expected 1 device enabled, got %d", n)
+	}
+	if device := fake.devices["file/"]; device == nil || device.Type != "file" {
+		t.Fatalf("expected a file device at file/, got %v", fake.devices)
+	}
+	data, err := ioutil.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The post-check requests go through the new device
+	if !strings.Contains(string(data), "/v1/auth/token/lookup-self") || !strings.Contains(string(data), "/v1/sys/audit-hash/file") {
+		t.Fatalf("post-check requests not logged:\n%s", data)
+	}
+
+	// The device listed by Vault is kept as is
+	if err := configureAuditDevices(client, config); err != nil {
+		t.Fatalf("configureAuditDevices on an existing device: %s", err)
+	}
+	if n := fake.enables(); n != 1 {
+		t.Fatalf("existing device enabled again, %d enables", n)
+	}
+}
+
+func TestConfigureAuditDevicesTypeMismatch(t *testing.T) {
+	fake, client := newFakeAuditVault(t)
+	fake.devices["file/"] = &vault.Audit{Type: "syslog", Path: "file/"}
+
+	err := configureAuditDevices(client, []auditDeviceConfig{{Type: "file", Options: map[string]string{"file_path": tempAuditFile(t)}}})
+	if err == nil || !strings.Contains(err.Error(), "instead of file") {
+		t.Fatalf("expected a type mismatch error, got %v", err)
+	}
+	if fake.enables() != 0 {
+		t.Fatalf("device enabled despite the mismatch")
+	}
+}
+
+func TestConfigureAuditDevicesBlocking(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full not available")
+	}
+	_, client := newFakeAuditVault(t)
+
+	// Writes to /dev/full fail, so Vault can't log and refuses the requests
+	err := configureAuditDevices(client, []auditDeviceConfig{{Type: "file", Options: map[string]string{"file_path": "/dev/full"}}})
+	if err == nil || !strings.Contains(err.Error(), "requests are failing") {
+		t.Fatalf("expected the post-check to fail, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// authMountConfig holds the mount settings shared by all the auth methods managed by this tool
+// Settings which are not set are left untouched
+type authMountConfig struct {
+	Description               string   `json:"description,omitempty"`
+	DefaultLeaseTTL           string   `json:"defaultLeaseTTL,omitempty"`
+	MaxLeaseTTL               string   `json:"maxLeaseTTL,omitempty"`
+	TokenType                 string   `json:"tokenType,omitempty"`
+	ListingVisibility         string   `json:"listingVisibility,omitempty"`
+	AuditNonHMACRequestKeys   []string `json:"auditNonHMACRequestKeys,omitempty"`
+	AuditNonHMACResponseKeys  []string `json:"auditNonHMACResponseKeys,omitempty"`
+	PassthroughRequestHeaders []string `json:"passthroughRequestHeaders,omitempty"`
+}
+
+// checkAuth returns true if an auth method is already mounted at path
+func checkAuth(client *vault.Client, path string) (bool, error) {
+	auths, err := client.Sys().ListAuth()
+	if err != nil {
+		return false, err
+	}
+	if auth, _ := auths[strings.TrimSuffix(path, "/")+"/"]; auth != nil {
+		return true, nil
+	}
+	return false, nil
+}
+
+// enableAuth mounts an auth method of type authType at path, unless already enabled
+// in which case the mount is tuned if its settings drifted from config
+func enableAuth(client *vault.Client, path string, authType string, config authMountConfig) error {
+	enabled, err := checkAuth(client, path)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		log.Debugf("Auth method %s already enabled at %s", authType, path)
+		return tuneAuth(client, path, config)
+	}
+	if err := client.Sys().EnableAuthWithOptions(path, &vault.EnableAuthOptions{
+		Type:        authType,
+		Description: config.Description,
+		Config: vault.AuthConfigInput{
+			DefaultLeaseTTL:           config.DefaultLeaseTTL,
+			MaxLeaseTTL:               config.MaxLeaseTTL,
+			TokenType:                 config.TokenType,
+			ListingVisibility:         config.ListingVisibility,
+			AuditNonHMACRequestKeys:   config.AuditNonHMACRequestKeys,
+			AuditNonHMACResponseKeys:  config.AuditNonHMACResponseKeys,
+			PassthroughRequestHeaders: config.PassthroughRequestHeaders,
+		},
+	}); err != nil {
+		return err
+	}
+	log.Infof("Auth method %s enabled at %s", authType, path)
+	return nil
+}
+
+// tuneAuth re-applies the configured settings of the auth mount at path when they drifted
+func tuneAuth(client *vault.Client, path string, config authMountConfig) error {
+	tunePath := fmt.Sprintf("sys/auth/%s/tune", strings.TrimSuffix(path, "/"))
+	current, err := client.Logical().Read(tunePath)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("cannot read tuning of auth mount %s", path)
+	}
+
+	data, err := authTuneDrift(path, current.Data, config)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		log.Debugf("Auth mount %s tuning up to date", path)
+		return nil
+	}
+	if _, err := client.Logical().Write(tunePath, data); err != nil {
+		return err
+	}
+	log.Infof("Auth mount %s tuned", path)
+	return nil
+}
+
+// authTuneDrift returns the configured settings which differ from the current tuning of the auth mount at path
+// current holds the data read from sys/auth/<path>/tune
+func authTuneDrift(path string, current map[string]interface{}, config authMountConfig) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	if config.Description != "" && current["description"] != config.Description {
+		data["description"] = config.Description
+	}
+	for key, ttl := range map[string]string{
+		"default_lease_ttl": config.DefaultLeaseTTL,
+		"max_lease_ttl":     config.MaxLeaseTTL,
+	} {
+		if ttl == "" {
+			continue
+		}
+		seconds, err := parseTTL(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s for auth mount %s - %s", key, path, err.Error())
+		}
+		if currentSeconds, _ := parseTTL(fmt.Sprint(current[key])); currentSeconds != seconds {
+			data[key] = ttl
+		}
+	}
+	for key, value := range map[string]string{
+		"token_type":         config.TokenType,
+		"listing_visibility": config.ListingVisibility,
+	} {
+		if value != "" && current[key] != value {
+			data[key] = value
+		}
+	}
+	for key, values := range map[string][]string{
+		"audit_non_hmac_request_keys":  config.AuditNonHMACRequestKeys,
+		"audit_non_hmac_response_keys": config.AuditNonHMACResponseKeys,
+		"passthrough_request_headers":  config.PassthroughRequestHeaders,
+	} {
+		if values != nil && !equalStrings(toStrings(current[key]), values) {
+			data[key] = values
+		}
+	}
+	return data, nil
+}
+
+// managedAuthMethods returns the types of the auth methods managed by this tool, by mount path
+func managedAuthMethods(config *bootstrapConfig) map[string]string {
+	methods := map[string]string{}
+	add := func(enabled bool, path string, defaultPath string, authType string) {
+		if !enabled {
+			return
+		}
+		if path == "" {
+			path = defaultPath
+		}
+		methods[strings.Trim(path, "/")] = authType
+	}
+	add(vaultK8sAuth, "", "kubernetes", "kubernetes")
+	add(vaultAppRole, config.AppRole.Path, DefaultAppRolePath, "approle")
+	add(vaultJwtAuth, config.JwtAuth.Path, DefaultJwtAuthPath, "jwt")
+	add(vaultUserpass, config.Userpass.Path, DefaultUserpassPath, "userpass")
+	add(vaultCertAuth, config.CertAuth.Path, DefaultCertAuthPath, "cert")
+	return methods
+}
@@ -0,0 +1,58 @@
+package bootstrap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuthTuneDrift(t *testing.T) {
+	current := map[string]interface{}{
+		"description":                  "K8s",
+		"default_lease_ttl":            3600,
+		"max_lease_ttl":                86400,
+		"token_type":                   "default-service",
+		"listing_visibility":           "",
+		"audit_non_hmac_request_keys":  []interface{}{"role", "name"},
+		"audit_non_hmac_response_keys": []interface{}{},
+	}
+	tests := []struct {
+		name     string
+		config   authMountConfig
+		expected map[string]interface{}
+	}{
+		{"nothing configured", authMountConfig{}, map[string]interface{}{}},
+		{"same values", authMountConfig{
+			Description:             "K8s",
+			DefaultLeaseTTL:         "1h",
+			MaxLeaseTTL:             "86400",
+			TokenType:               "default-service",
+			AuditNonHMACRequestKeys: []string{"name", "role"},
+		}, map[string]interface{}{}},
+		{"changed values", authMountConfig{
+			Description:       "Kubernetes",
+			DefaultLeaseTTL:   "30m",
+			ListingVisibility: "unauth",
+		}, map[string]interface{}{"description": "Kubernetes", "default_lease_ttl": "30m", "listing_visibility": "unauth"}},
+		{"changed lists", authMountConfig{
+			AuditNonHMACRequestKeys:   []string{"role"},
+			PassthroughRequestHeaders: []string{"X-Request-Id"},
+		}, map[string]interface{}{"audit_non_hmac_request_keys": []string{"role"}, "passthrough_request_headers": []string{"X-Request-Id"}}},
+		{"emptied list", authMountConfig{AuditNonHMACRequestKeys: []string{}},
+			map[string]interface{}{"audit_non_hmac_request_keys": []string{}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := authTuneDrift("kubernetes", current, test.config)
+			if err != nil {
+				t.Fatalf("authTuneDrift: %s", err)
+			}
+			if !reflect.DeepEqual(data, test.expected) {
+				t.Fatalf("got %v, want %v", data, test.expected)
+			}
+		})
+	}
+
+	if _, err := authTuneDrift("kubernetes", current, authMountConfig{MaxLeaseTTL: "1 day"}); err == nil {
+		t.Fatal("expected an error for an invalid TTL")
+	}
+}
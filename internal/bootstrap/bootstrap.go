@@ -2,8 +2,8 @@ package bootstrap
 
 import (
 	"context"
+	"fmt"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
@@ -13,30 +13,48 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
+// RunInit runs only the initialization step of Run
+func RunInit() {
+	vaultInit = true
+	vaultUnseal = false
+	disableConfigureSteps()
+	Run()
+}
+
+// RunUnseal runs only the unseal step of Run
+func RunUnseal() {
+	vaultInit = false
+	vaultTLS = false
+	vaultUnseal = true
+	disableConfigureSteps()
+	Run()
+}
+
+// RunConfigure runs only the configuration steps of Run
+func RunConfigure() {
+	vaultInit = false
+	vaultTLS = false
+	vaultUnseal = false
+	Run()
+}
+
 // Run Vault bootstrap
 func Run() {
 
 	// Create clientSet for k8s client-go
-	k8sConfig, err := rest.InClusterConfig()
+	clientsetK8s, err := newK8sClientset()
 	if err != nil {
 		log.Error(err.Error())
-		os.Exit(1)
-	}
-
-	//k8sConfig, _ := clientcmd.BuildConfigFromFlags("", os.Getenv("HOME")+"/.kube/config")
-
-	clientsetK8s, err := kubernetes.NewForConfig(k8sConfig)
-	if err != nil {
-		log.Error(err.Error())
-		os.Exit(1)
+		exit(1)
 	}
 
 	podsList, err := clientsetK8s.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		log.Error(err.Error())
-		os.Exit(1)
+		exit(1)
 	}
 	var pdList []string
 	for _, pd := range podsList.Items {
@@ -44,43 +62,36 @@ func Run() {
 	}
 	log.Debugf("Pods list: %s", strings.Join(pdList, ";"))
 
+	config, err := loadConfig(vaultBootstrapConfig)
+	if err != nil {
+		log.Error(err.Error())
+		exit(1)
+	}
+
+	// Vault listener certificate must exist before Vault can start
+	if vaultTLS {
+		if err := configureVaultTLS(clientsetK8s, config.TLS); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
 	// Define Vault client for Vault LB
-	clientConfigLB := vault.DefaultConfig()
-	// Skip TLS verification for initialization
-	insecureTLS := &vault.TLSConfig{
-		Insecure: true,
+	clientConfigLB, err := newVaultLBConfig()
+	if err != nil {
+		log.Error(err.Error())
+		exit(1)
 	}
-	clientConfigLB.ConfigureTLS(insecureTLS)
 
 	clientLB, err := vault.NewClient(clientConfigLB)
 	if err != nil {
-		os.Exit(1)
+		exit(1)
 	}
 
-	// Slice of maps containing vault pods details
-	var vaultPods []vaultPod
-	vaultMembersUrls := strings.Split(vaultClusterMembers, ",")
-	// Generate the slice from Env variable
-	for _, member := range vaultMembersUrls {
-		var pod vaultPod
-		podFqdn, _ := url.Parse(member)
-		pod.fqdn = member
-		pod.name = strings.Split(podFqdn.Hostname(), ".")[0]
-		// Define main client (vault-0) which will be used for initialization
-		// When using integrated RAFT storage, the vault cluster member that is initialized
-		// needs to be first one which is unsealed
-		// In the unseal part we'll always start with the first member
-		clientConfig := &vault.Config{
-			Address: pod.fqdn,
-		}
-		clientConfig.ConfigureTLS(insecureTLS)
-
-		client, err := vault.NewClient(clientConfig)
-		if err != nil {
-			os.Exit(1)
-		}
-		pod.client = client
-		vaultPods = append(vaultPods, pod)
+	vaultPods, err := newVaultPods()
+	if err != nil {
+		log.Error(err.Error())
+		exit(1)
 	}
 	// Define main client (vault-0) which will be used for initialization
 	// When using integrated RAFT storage, the vault cluster member that is initialized
@@ -104,13 +115,13 @@ func Run() {
 		if err != nil {
 			log.Debugf("Starting bootstrap")
 			log.Errorf(err.Error())
-			os.Exit(1)
+			exit(1)
 		}
 		if !init {
 			rootToken, unsealKeys, err = operatorInit(vaultFirstPod)
 			if err != nil {
 				log.Error(err.Error())
-				os.Exit(1)
+				exit(1)
 			}
 			// If flag for creating k8s secrets is set
 			if vaultK8sSecret {
@@ -121,26 +132,26 @@ func Run() {
 					if errors.IsNotFound(err) {
 						if errI := createK8sSecret(clientsetK8s, &vaultSecretRoot, rootToken); errI != nil {
 							log.Error(errI.Error())
-							os.Exit(1)
+							exit(1)
 						}
 					} else {
 						log.Error(err.Error())
-						os.Exit(1)
+						exit(1)
 					}
 				}
 				// Check if vault secret unseal exists
-				_, err = getValuesFromK8sSecret(clientsetK8s, pVaultSecretRoot)
+				_, err = getValuesFromK8sSecret(clientsetK8s, pVaultSecretUnseal)
 				if err != nil {
 					// if it fails because secret is not found, create the secret
 					if errors.IsNotFound(err) {
 						unsealKeysString := strings.Join(*unsealKeys, ";")
 						if errI := createK8sSecret(clientsetK8s, &vaultSecretUnseal, &unsealKeysString); errI != nil {
 							log.Error(errI.Error())
-							os.Exit(1)
+							exit(1)
 						}
 					} else {
 						log.Error(err.Error())
-						os.Exit(1)
+						exit(1)
 					}
 				}
 			} else {
@@ -152,10 +163,11 @@ func Run() {
 	}
 
 	// Check if unseal keys in memory and if not load them
-	if unsealKeys == nil {
+	if vaultUnseal && unsealKeys == nil {
 		unsealKeysString, err := getValuesFromK8sSecret(clientsetK8s, pVaultSecretUnseal)
 		if err != nil {
-			panic("Cannot load Unseal Keys")
+			log.Errorf("Cannot load Unseal Keys - %s", err.Error())
+			exit(1)
 		}
 		npUnsealKeys := strings.Split(*unsealKeysString, ";")
 		unsealKeys = &npUnsealKeys
@@ -173,26 +185,245 @@ func Run() {
 		}
 	}
 
-	if vaultK8sAuth {
-		up := checkVaultUp(clientLB)
-		if !up {
-			panic("K8s authentication: Vault not ready. Cannot proceed")
+	// Steps below configure Vault and require it to be unsealed
+	if !configureEnabled() {
+		return
+	}
+
+	up := checkVaultUp(clientLB)
+	if !up {
+		log.Error("Vault not ready. Cannot proceed with configuration")
+		exit(1)
+	}
+
+	// Check if root token in memory and if not load it
+	if rootToken == nil {
+		rootToken = generatedRootToken
+	}
+	if rootToken == nil {
+		rootToken, err = loadRootToken(clientsetK8s)
+		if err != nil {
+			log.Error(err.Error())
+			log.Error("Cannot load Root Token")
+			exit(1)
 		}
+		log.Debug("Root Token loaded successfully")
+	}
+
+	// set root token
+	clientLB.SetToken(*rootToken)
 
-		// set root token
-		clientLB.SetToken(*rootToken)
+	// Enable audit first, so that the configuration steps below are audited
+	if vaultAudit {
+		if err := configureAuditDevices(clientLB, config.AuditDevices); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultK8sAuth {
 		k8sAuth, err := checkK8sAuth(clientLB)
 		if err != nil {
 			log.Errorf(err.Error())
-			os.Exit(1)
+			exit(1)
 		}
 		if k8sAuth {
 			log.Info("K8s authentication: Already enabled")
-			return
+			if err := tuneAuth(clientLB, "kubernetes/", config.K8sAuth.Tune); err != nil {
+				log.Error(err.Error())
+				exit(1)
+			}
+		} else if err := configureK8sAuth(clientLB, clientsetK8s, config.K8sAuth); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultAppRole {
+		if err := configureAppRole(clientLB, clientsetK8s, config.AppRole); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultJwtAuth {
+		if err := configureJwtAuth(clientLB, clientsetK8s, config.JwtAuth); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultUserpass {
+		if err := configureUserpass(clientLB, clientsetK8s, config.Userpass); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultCertAuth {
+		if err := configureCertAuth(clientLB, clientsetK8s, config.CertAuth); err != nil {
+			log.Error(err.Error())
+			exit(1)
 		}
-		if err := configureK8sAuth(clientLB, clientsetK8s); err != nil {
+	}
+
+	if vaultSecretsEngines {
+		if err := configureSecretsEngines(clientLB, config); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultPKI {
+		if err := configurePKI(clientLB, clientsetK8s, config.PKI); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultTransit {
+		if err := configureTransit(clientLB, config.Transit); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultKVImport {
+		if err := configureKVImport(clientLB, clientsetK8s, config.KVImport); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultDatabase {
+		if err := configureDatabase(clientLB, clientsetK8s, config.Database); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultSSH {
+		if err := configureSSH(clientLB, clientsetK8s, config.SSH); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+
+	if vaultK8sEngine {
+		if err := configureK8sSecretsEngine(clientLB, clientsetK8s, config.K8sSecretsEngine); err != nil {
 			log.Error(err.Error())
-			os.Exit(1)
+			exit(1)
 		}
 	}
+
+	if vaultIdentity {
+		if err := configureIdentity(clientLB, config.Identity); err != nil {
+			log.Error(err.Error())
+			exit(1)
+		}
+	}
+}
+
+// k8sRestConfig is the configuration of the K8s clients, loaded once by newK8sConfig
+var k8sRestConfig *rest.Config
+
+// k8sInCluster is set by newK8sConfig when running in a pod
+var k8sInCluster bool
+
+// newK8sConfig returns the configuration of the pod's service account when running in a pod,
+// else the configuration of the current (or --context) context of KUBECONFIG or ~/.kube/config
+func newK8sConfig() (*rest.Config, error) {
+	if k8sRestConfig != nil {
+		return k8sRestConfig, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err == nil {
+		k8sRestConfig = config
+		k8sInCluster = true
+		return config, nil
+	}
+	if err != rest.ErrNotInCluster {
+		return nil, err
+	}
+
+	log.Debug("Not running in a pod. Loading kubeconfig")
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	)
+	config, err = clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Not running in a pod and cannot load kubeconfig - %s", err.Error())
+	}
+	// Without NAMESPACE or --namespace, use the namespace of the context
+	if namespace == "" {
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("Using kubeconfig: API %s, namespace %s", config.Host, namespace)
+	k8sRestConfig = config
+	return config, nil
+}
+
+// newK8sClientset returns a K8s client using the configuration returned by newK8sConfig
+func newK8sClientset() (*kubernetes.Clientset, error) {
+	k8sConfig, err := newK8sConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(k8sConfig)
+}
+
+// newVaultLBConfig returns the client configuration of the Vault service (VAULT_ADDR)
+// With port-forward, the service is reached through the first cluster member
+func newVaultLBConfig() (*vault.Config, error) {
+	config := vault.DefaultConfig()
+	config.Address = vaultAddr
+	// Verify against the CA issued by the TLS step or skip TLS verification for initialization
+	if err := configureClientTLS(config); err != nil {
+		return nil, err
+	}
+	if vaultPortForward {
+		if err := configureForwardedClient(config, strings.Split(vaultClusterMembers, ",")[0]); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// newVaultPods returns the members of VAULT_CLUSTER_MEMBERS, each with its own Vault client
+func newVaultPods() ([]vaultPod, error) {
+	// Slice of maps containing vault pods details
+	var vaultPods []vaultPod
+	vaultMembersUrls := strings.Split(vaultClusterMembers, ",")
+	// Generate the slice from Env variable
+	for _, member := range vaultMembersUrls {
+		var pod vaultPod
+		podFqdn, err := url.Parse(member)
+		if err != nil {
+			return nil, err
+		}
+		pod.fqdn = member
+		pod.name = strings.Split(podFqdn.Hostname(), ".")[0]
+		clientConfig := &vault.Config{
+			Address: pod.fqdn,
+		}
+		if err := configureClientTLS(clientConfig); err != nil {
+			return nil, err
+		}
+		if vaultPortForward {
+			if err := configureForwardedClient(clientConfig, member); err != nil {
+				return nil, err
+			}
+		}
+		client, err := vault.NewClient(clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		pod.client = client
+		pod.httpClient = clientConfig.HttpClient
+		vaultPods = append(vaultPods, pod)
+	}
+	return vaultPods, nil
 }
@@ -0,0 +1,119 @@
+package bootstrap
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultCertAuthPath        = "cert"
+	DefaultCertAuthCASecretKey = "ca.crt"
+)
+
+type certAuthConfig struct {
+	Path  string          `json:"path"`
+	Tune  authMountConfig `json:"tune"`
+	Roles []certAuthRole  `json:"roles"`
+}
+
+type certAuthRole struct {
+	Name string `json:"name"`
+	// K8s secret holding the trusted CA bundle (PEM)
+	CASecret string `json:"caSecret"`
+	// Key of the CA bundle in the K8s secret. Defaults to ca.crt
+	CASecretKey                string   `json:"caSecretKey"`
+	AllowedCommonNames         []string `json:"allowedCommonNames"`
+	AllowedDNSSANs             []string `json:"allowedDNSSANs"`
+	AllowedEmailSANs           []string `json:"allowedEmailSANs"`
+	AllowedURISANs             []string `json:"allowedURISANs"`
+	AllowedOrganizationalUnits []string `json:"allowedOrganizationalUnits"`
+	Policies                   []string `json:"policies"`
+	TokenTTL                   string   `json:"tokenTTL"`
+	TokenMaxTTL                string   `json:"tokenMaxTTL"`
+}
+
+func configureCertAuth(client *vault.Client, clientsetK8s *kubernetes.Clientset, config certAuthConfig) error {
+	path := config.Path
+	if path == "" {
+		path = DefaultCertAuthPath
+	}
+	if err := enableAuth(client, path, "cert", config.Tune); err != nil {
+		return fmt.Errorf("TLS certificate authentication: %s", err.Error())
+	}
+	for _, role := range config.Roles {
+		if err := configureCertAuthRole(client, clientsetK8s, path, role); err != nil {
+			return fmt.Errorf("TLS certificate authentication: role %s: %s", role.Name, err.Error())
+		}
+	}
+	log.Info("TLS certificate authentication: Successfully configured")
+	return nil
+}
+
+func configureCertAuthRole(client *vault.Client, clientsetK8s *kubernetes.Clientset, path string, role certAuthRole) error {
+	if role.Name == "" {
+		return fmt.Errorf("name is mandatory")
+	}
+	if role.CASecret == "" {
+		return fmt.Errorf("caSecret is mandatory")
+	}
+	caKey := role.CASecretKey
+	if caKey == "" {
+		caKey = DefaultCertAuthCASecretKey
+	}
+	secret, err := getK8sSecret(clientsetK8s, role.CASecret)
+	if err != nil {
+		return fmt.Errorf("Can't get CA bundle secret %s - %s", role.CASecret, err.Error())
+	}
+	caBundle, ok := secret.Data[caKey]
+	if !ok {
+		return fmt.Errorf("key %s not found in K8s secret %s", caKey, role.CASecret)
+	}
+	if err := checkCABundle(caBundle); err != nil {
+		return fmt.Errorf("invalid CA bundle in K8s secret %s - %s", role.CASecret, err.Error())
+	}
+
+	data := map[string]interface{}{
+		"certificate":                  string(caBundle),
+		"allowed_common_names":         role.AllowedCommonNames,
+		"allowed_dns_sans":             role.AllowedDNSSANs,
+		"allowed_email_sans":           role.AllowedEmailSANs,
+		"allowed_uri_sans":             role.AllowedURISANs,
+		"allowed_organizational_units": role.AllowedOrganizationalUnits,
+		"token_policies":               role.Policies,
+		"token_ttl":                    role.TokenTTL,
+		"token_max_ttl":                role.TokenMaxTTL,
+	}
+	if _, err := client.Logical().Write(fmt.Sprintf("auth/%s/certs/%s", path, role.Name), data); err != nil {
+		return err
+	}
+	log.Infof("TLS certificate authentication: Role %s configured", role.Name)
+	return nil
+}
+
+// checkCABundle verifies the bundle contains only valid PEM certificates
+func checkCABundle(caBundle []byte) error {
+	found := 0
+	for rest := caBundle; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block %s", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return err
+		}
+		found++
+	}
+	if found == 0 {
+		return fmt.Errorf("no certificate found")
+	}
+	return nil
+}
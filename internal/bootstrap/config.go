@@ -0,0 +1,64 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// bootstrapConfig holds the structured configuration (roles, mounts...) which
+// cannot be expressed as environment variables. It is loaded from the YAML file
+// pointed by VAULT_BOOTSTRAP_CONFIG
+type bootstrapConfig struct {
+	Settings *settings `json:"settings"`
+
+	TLS vaultTLSConfig `json:"tls"`
+
+	AuditDevices []auditDeviceConfig `json:"auditDevices"`
+
+	K8sAuth  k8sAuthConfig  `json:"kubernetes"`
+	AppRole  appRoleConfig  `json:"approle"`
+	JwtAuth  jwtAuthConfig  `json:"jwt"`
+	Userpass userpassConfig `json:"userpass"`
+	CertAuth certAuthConfig `json:"cert"`
+
+	SecretsEngines []secretsEngineConfig `json:"secretsEngines"`
+	// Unmount the secrets engines which are not part of the configuration
+	PruneSecretsEngines bool `json:"pruneSecretsEngines"`
+
+	PKI      pkiConfig      `json:"pki"`
+	Transit  transitConfig  `json:"transit"`
+	Database databaseConfig `json:"database"`
+	SSH      sshConfig      `json:"ssh"`
+
+	K8sSecretsEngine k8sSecretsEngineConfig `json:"kubernetesSecretsEngine"`
+
+	KVImport kvImportConfig `json:"kvImport"`
+
+	Identity identityConfig `json:"identity"`
+}
+
+func loadConfig(path string) (*bootstrapConfig, error) {
+	defaults := defaultSettings()
+	config := &bootstrapConfig{Settings: &defaults}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Warnf("Configuration file %s not found. Using defaults", path)
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("Invalid configuration file %s: %s", path, err.Error())
+	}
+	// An empty settings section is decoded as null, which replaces the defaults
+	if config.Settings == nil {
+		config.Settings = &defaults
+	}
+	log.Debugf("Configuration loaded from %s", path)
+	return config, nil
+}
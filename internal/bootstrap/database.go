@@ -0,0 +1,250 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultDatabasePath        = "database"
+	DefaultDatabaseUsernameKey = "username"
+	DefaultDatabasePasswordKey = "password"
+	// Annotation of the credentials secret listing the <path>/<connection> whose admin password was rotated
+	DatabaseRootRotatedAnnotation = "vault-bootstrap/root-rotated"
+)
+
+type databaseConfig struct {
+	Path        string                     `json:"path"`
+	Connections []databaseConnectionConfig `json:"connections"`
+	Roles       []databaseRoleConfig       `json:"roles"`
+	StaticRoles []databaseStaticRoleConfig `json:"staticRoles"`
+}
+
+type databaseConnectionConfig struct {
+	Name string `json:"name"`
+	// e.g. postgresql-database-plugin, mysql-database-plugin
+	Plugin string `json:"plugin"`
+	// Template using {{username}} and {{password}}
+	ConnectionURL string   `json:"connectionURL"`
+	AllowedRoles  []string `json:"allowedRoles"`
+	// K8s secret holding the admin credentials
+	CredentialsSecret string `json:"credentialsSecret"`
+	// Keys of the admin credentials in the K8s secret. Default to username and password
+	UsernameKey string `json:"usernameKey"`
+	PasswordKey string `json:"passwordKey"`
+	// Rotate the admin password once the connection is created, so that only Vault knows it
+	RotateRoot bool `json:"rotateRoot"`
+	// Extra plugin specific parameters (e.g. max_open_connections)
+	Options map[string]interface{} `json:"options"`
+}
+
+type databaseRoleConfig struct {
+	Name                 string   `json:"name"`
+	Connection           string   `json:"connection"`
+	CreationStatements   []string `json:"creationStatements"`
+	RevocationStatements []string `json:"revocationStatements"`
+	RollbackStatements   []string `json:"rollbackStatements"`
+	RenewStatements      []string `json:"renewStatements"`
+	DefaultTTL           string   `json:"defaultTTL"`
+	MaxTTL               string   `json:"maxTTL"`
+}
+
+type databaseStaticRoleConfig struct {
+	Name               string   `json:"name"`
+	Connection         string   `json:"connection"`
+	Username           string   `json:"username"`
+	RotationPeriod     string   `json:"rotationPeriod"`
+	RotationStatements []string `json:"rotationStatements"`
+}
+
+func databasePath(config databaseConfig) string {
+	if config.Path == "" {
+		return DefaultDatabasePath
+	}
+	return strings.Trim(config.Path, "/")
+}
+
+func configureDatabase(client *vault.Client, clientsetK8s *kubernetes.Clientset, config databaseConfig) error {
+	path := databasePath(config)
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("Database: %s", err.Error())
+	}
+	if err := mountSecretsEngine(client, mounts, secretsEngineConfig{
+		Path: path,
+		Type: "database",
+	}); err != nil {
+		return fmt.Errorf("Database: %s", err.Error())
+	}
+	for _, connection := range config.Connections {
+		if err := configureDatabaseConnection(client, clientsetK8s, path, connection); err != nil {
+			return fmt.Errorf("Database: connection %s: %s", connection.Name, err.Error())
+		}
+	}
+	for _, role := range config.Roles {
+		if err := configureDatabaseRole(client, path, role); err != nil {
+			return fmt.Errorf("Database: role %s: %s", role.Name, err.Error())
+		}
+	}
+	for _, role := range config.StaticRoles {
+		if err := configureDatabaseStaticRole(client, path, role); err != nil {
+			return fmt.Errorf("Database: static role %s: %s", role.Name, err.Error())
+		}
+	}
+	log.Info("Database: Successfully configured")
+	return nil
+}
+
+func configureDatabaseConnection(client *vault.Client, clientsetK8s *kubernetes.Clientset, path string, connection databaseConnectionConfig) error {
+	if connection.Name == "" || connection.Plugin == "" || connection.ConnectionURL == "" {
+		return fmt.Errorf("name, plugin and connectionURL are mandatory")
+	}
+	configPath := fmt.Sprintf("%s/config/%s", path, connection.Name)
+	current, err := client.Logical().Read(configPath)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{}
+	for k, v := range connection.Options {
+		data[k] = v
+	}
+	data["plugin_name"] = connection.Plugin
+	data["connection_url"] = connection.ConnectionURL
+	data["allowed_roles"] = connection.AllowedRoles
+
+	// Once rotated, the admin password is known only by Vault, so the one from
+	// the K8s secret is not valid anymore. Updates of an existing connection keep it
+	if current == nil || !connection.RotateRoot {
+		if connection.CredentialsSecret == "" {
+			return fmt.Errorf("credentialsSecret is mandatory")
+		}
+		username, password, err := getDatabaseCredentials(clientsetK8s, connection)
+		if err != nil {
+			return err
+		}
+		data["username"] = username
+		data["password"] = password
+	}
+
+	if _, err := client.Logical().Write(configPath, data); err != nil {
+		return err
+	}
+	if current != nil {
+		log.Infof("Database: Connection %s updated", connection.Name)
+	} else {
+		log.Infof("Database: Connection %s created", connection.Name)
+	}
+
+	// The rotation is recorded on the credentials secret, so that a failed one is retried by the next run.
+	// Vault keeps the password it was given until the rotation succeeds, so retrying is safe
+	if connection.RotateRoot {
+		rotated, err := databaseRootRotated(clientsetK8s, path, connection)
+		if err != nil {
+			return err
+		}
+		if rotated {
+			return nil
+		}
+		if _, err := client.Logical().Write(fmt.Sprintf("%s/rotate-root/%s", path, connection.Name), nil); err != nil {
+			return fmt.Errorf("cannot rotate root credentials - %s", err.Error())
+		}
+		log.Warnf("Database: Root credentials of %s rotated. The password from K8s secret %s is not valid anymore", connection.Name, connection.CredentialsSecret)
+		if err := markDatabaseRootRotated(clientsetK8s, path, connection); err != nil {
+			log.Warnf("Database: Cannot record the rotation of %s on K8s secret %s - %s. It will be rotated again by the next run", connection.Name, connection.CredentialsSecret, err.Error())
+		}
+	}
+	return nil
+}
+
+// databaseRootRotated tells whether the rotation of the admin password of the connection is recorded
+func databaseRootRotated(clientsetK8s *kubernetes.Clientset, path string, connection databaseConnectionConfig) (bool, error) {
+	if connection.CredentialsSecret == "" {
+		return false, fmt.Errorf("credentialsSecret is mandatory")
+	}
+	secret, err := getK8sSecret(clientsetK8s, connection.CredentialsSecret)
+	if err != nil {
+		return false, fmt.Errorf("Can't get credentials secret %s - %s", connection.CredentialsSecret, err.Error())
+	}
+	ref := path + "/" + connection.Name
+	for _, rotated := range strings.Split(secret.Annotations[DatabaseRootRotatedAnnotation], ",") {
+		if rotated == ref {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func markDatabaseRootRotated(clientsetK8s *kubernetes.Clientset, path string, connection databaseConnectionConfig) error {
+	secret, err := getK8sSecret(clientsetK8s, connection.CredentialsSecret)
+	if err != nil {
+		return err
+	}
+	rotated := secret.Annotations[DatabaseRootRotatedAnnotation]
+	if rotated != "" {
+		rotated += ","
+	}
+	return annotateK8sSecret(clientsetK8s, connection.CredentialsSecret, DatabaseRootRotatedAnnotation, rotated+path+"/"+connection.Name)
+}
+
+func getDatabaseCredentials(clientsetK8s *kubernetes.Clientset, connection databaseConnectionConfig) (string, string, error) {
+	usernameKey := connection.UsernameKey
+	if usernameKey == "" {
+		usernameKey = DefaultDatabaseUsernameKey
+	}
+	passwordKey := connection.PasswordKey
+	if passwordKey == "" {
+		passwordKey = DefaultDatabasePasswordKey
+	}
+	secret, err := getK8sSecret(clientsetK8s, connection.CredentialsSecret)
+	if err != nil {
+		return "", "", fmt.Errorf("Can't get credentials secret %s - %s", connection.CredentialsSecret, err.Error())
+	}
+	username, okUsername := secret.Data[usernameKey]
+	password, okPassword := secret.Data[passwordKey]
+	if !okUsername || !okPassword {
+		return "", "", fmt.Errorf("K8s secret %s must contain %s and %s", connection.CredentialsSecret, usernameKey, passwordKey)
+	}
+	return string(username), string(password), nil
+}
+
+func configureDatabaseRole(client *vault.Client, path string, role databaseRoleConfig) error {
+	if role.Name == "" || role.Connection == "" {
+		return fmt.Errorf("name and connection are mandatory")
+	}
+	data := map[string]interface{}{
+		"db_name":               role.Connection,
+		"creation_statements":   role.CreationStatements,
+		"revocation_statements": role.RevocationStatements,
+		"rollback_statements":   role.RollbackStatements,
+		"renew_statements":      role.RenewStatements,
+		"default_ttl":           role.DefaultTTL,
+		"max_ttl":               role.MaxTTL,
+	}
+	if _, err := client.Logical().Write(fmt.Sprintf("%s/roles/%s", path, role.Name), data); err != nil {
+		return err
+	}
+	log.Infof("Database: Role %s configured", role.Name)
+	return nil
+}
+
+func configureDatabaseStaticRole(client *vault.Client, path string, role databaseStaticRoleConfig) error {
+	if role.Name == "" || role.Connection == "" || role.Username == "" {
+		return fmt.Errorf("name, connection and username are mandatory")
+	}
+	data := map[string]interface{}{
+		"db_name":             role.Connection,
+		"username":            role.Username,
+		"rotation_period":     role.RotationPeriod,
+		"rotation_statements": role.RotationStatements,
+	}
+	if _, err := client.Logical().Write(fmt.Sprintf("%s/static-roles/%s", path, role.Name), data); err != nil {
+		return err
+	}
+	log.Infof("Database: Static role %s configured", role.Name)
+	return nil
+}
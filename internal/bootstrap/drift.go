@@ -0,0 +1,73 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type driftReport struct {
+	Timestamp string `json:"timestamp"`
+	Drift     bool   `json:"drift"`
+	statePlan
+}
+
+// Drift compares Vault with the desired state, using only read endpoints, records the
+// report in the ConfigMap VAULT_DRIFT_CONFIGMAP and returns the exit code
+func Drift(output string) int {
+	client, state, err := prepareState(output)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	changes, err := planState(client, state)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	report := driftReport{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Drift:     len(changes) > 0,
+		statePlan: newStatePlan(changes),
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	if output == "json" {
+		fmt.Println(string(data))
+	} else if report.Drift {
+		fmt.Print(formatStateChanges(changes))
+		fmt.Printf("Drift: %d to create, %d to update, %d to delete\n", report.Summary[stateActionCreate], report.Summary[stateActionUpdate], report.Summary[stateActionDelete])
+	} else {
+		fmt.Println("No drift. Vault matches the desired state")
+	}
+
+	if err := recordDriftReport(report, string(data)); err != nil {
+		log.Errorf("Drift: Can't record the report in ConfigMap %s - %s", vaultDriftConfigMap, err.Error())
+		return ExitError
+	}
+	if report.Drift {
+		log.Warnf("Drift: %d differences with the desired state", len(changes))
+		return ExitChanges
+	}
+	log.Info("Drift: No differences with the desired state")
+	return ExitNoChanges
+}
+
+func recordDriftReport(report driftReport, data string) error {
+	clientsetK8s, err := newK8sClientset()
+	if err != nil {
+		return err
+	}
+	return applyK8sConfigMap(clientsetK8s, vaultDriftConfigMap, map[string]string{
+		"timestamp":   report.Timestamp,
+		"drift":       strconv.FormatBool(report.Drift),
+		"report.json": data,
+	})
+}
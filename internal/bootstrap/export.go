@@ -0,0 +1,280 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// Endpoints listing the roles of each auth method type, relative to the mount
+var exportAuthRolePaths = map[string]string{
+	"kubernetes": "role",
+	"approle":    "role",
+	"jwt":        "role",
+	"oidc":       "role",
+	"userpass":   "users",
+	"cert":       "certs",
+	"aws":        "role",
+	"gcp":        "role",
+	"azure":      "role",
+}
+
+// Auth method types whose mount configuration (<mount>/config) is exported
+var exportAuthConfigTypes = []string{"kubernetes", "jwt", "oidc", "aws", "gcp", "azure", "ldap"}
+
+// Fields which are required to recreate an entry but are never returned by Vault
+var exportWriteOnlyFields = map[string][]string{
+	"userpass": {"password"},
+}
+
+// Deprecated role fields returned next to their token_* equivalent
+var exportDeprecatedFields = map[string]string{
+	"policies":    "token_policies",
+	"ttl":         "token_ttl",
+	"max_ttl":     "token_max_ttl",
+	"period":      "token_period",
+	"bound_cidrs": "token_bound_cidrs",
+	"num_uses":    "token_num_uses",
+}
+
+// Export writes the current Vault configuration as a desired state document to path,
+// or to stdout if path is empty, and returns the exit code
+func Export(path string) int {
+	// Keep stdout parseable
+	if path == "" {
+		log.SetOutput(os.Stderr)
+	}
+	client, err := newTokenClient()
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	state, err := exportState(client)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	if path == "" {
+		fmt.Print(string(data))
+		return ExitOK
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	log.Infof("Export: Desired state written to %s", path)
+	return ExitOK
+}
+
+// exportState reads the Vault configuration. Secret values are replaced with placeholders
+// referencing K8s secrets, which must be created before applying the document
+func exportState(client *vault.Client) (*desiredState, error) {
+	state := &desiredState{}
+
+	audits, err := client.Sys().ListAudit()
+	if err != nil {
+		return nil, fmt.Errorf("Export: %s", err.Error())
+	}
+	for _, path := range sortedMountPaths(audits) {
+		audit := audits[path]
+		state.AuditDevices = append(state.AuditDevices, auditDeviceConfig{
+			Path:        strings.TrimSuffix(path, "/"),
+			Type:        audit.Type,
+			Description: audit.Description,
+			Options:     audit.Options,
+			Local:       audit.Local,
+		})
+	}
+
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return nil, fmt.Errorf("Export: %s", err.Error())
+	}
+	for _, path := range sortedMountPaths(mounts) {
+		if isSystemMount(path) {
+			continue
+		}
+		state.Mounts = append(state.Mounts, exportSecretsEngine(path, mounts[path]))
+	}
+
+	auths, err := client.Sys().ListAuth()
+	if err != nil {
+		return nil, fmt.Errorf("Export: %s", err.Error())
+	}
+	for _, path := range sortedMountPaths(auths) {
+		if containsString(systemAuthMethods, path) {
+			continue
+		}
+		auth := auths[path]
+		mountPath := strings.TrimSuffix(path, "/")
+		state.AuthMethods = append(state.AuthMethods, authMethodConfig{
+			Path: mountPath,
+			Type: auth.Type,
+			Tune: exportAuthTune(auth),
+		})
+		resources, err := exportAuthResources(client, mountPath, auth.Type)
+		if err != nil {
+			return nil, fmt.Errorf("Export: auth method %s: %s", mountPath, err.Error())
+		}
+		state.Roles = append(state.Roles, resources...)
+	}
+
+	policies, err := client.Sys().ListPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("Export: %s", err.Error())
+	}
+	state.Policies = map[string]string{}
+	for _, name := range policies {
+		if name == "root" {
+			continue
+		}
+		rules, err := client.Sys().GetPolicy(name)
+		if err != nil {
+			return nil, fmt.Errorf("Export: policy %s: %s", name, err.Error())
+		}
+		state.Policies[name] = rules
+	}
+
+	log.Infof("Export: %d audit devices, %d secrets engines, %d auth methods, %d roles and %d policies exported",
+		len(state.AuditDevices), len(state.Mounts), len(state.AuthMethods), len(state.Roles), len(state.Policies))
+	return state, nil
+}
+
+func exportSecretsEngine(path string, mount *vault.MountOutput) secretsEngineConfig {
+	engine := secretsEngineConfig{
+		Path:        strings.TrimSuffix(path, "/"),
+		Type:        mount.Type,
+		Description: mount.Description,
+	}
+	if mount.Config.DefaultLeaseTTL != 0 {
+		engine.DefaultLeaseTTL = strconv.Itoa(mount.Config.DefaultLeaseTTL)
+	}
+	if mount.Config.MaxLeaseTTL != 0 {
+		engine.MaxLeaseTTL = strconv.Itoa(mount.Config.MaxLeaseTTL)
+	}
+	for k, v := range mount.Options {
+		if k == "version" && mount.Type == "kv" {
+			engine.Version, _ = strconv.Atoi(v)
+			continue
+		}
+		if engine.Options == nil {
+			engine.Options = map[string]string{}
+		}
+		engine.Options[k] = v
+	}
+	return engine
+}
+
+func exportAuthTune(auth *vault.AuthMount) authMountConfig {
+	tune := authMountConfig{
+		Description:               auth.Description,
+		TokenType:                 auth.Config.TokenType,
+		ListingVisibility:         auth.Config.ListingVisibility,
+		AuditNonHMACRequestKeys:   auth.Config.AuditNonHMACRequestKeys,
+		AuditNonHMACResponseKeys:  auth.Config.AuditNonHMACResponseKeys,
+		PassthroughRequestHeaders: auth.Config.PassthroughRequestHeaders,
+	}
+	if auth.Config.DefaultLeaseTTL != 0 {
+		tune.DefaultLeaseTTL = strconv.Itoa(auth.Config.DefaultLeaseTTL)
+	}
+	if auth.Config.MaxLeaseTTL != 0 {
+		tune.MaxLeaseTTL = strconv.Itoa(auth.Config.MaxLeaseTTL)
+	}
+	return tune
+}
+
+// exportAuthResources returns the configuration and the roles of the auth method mounted at path
+func exportAuthResources(client *vault.Client, path string, authType string) ([]stateResourceConfig, error) {
+	var resources []stateResourceConfig
+	if containsString(exportAuthConfigTypes, authType) {
+		configPath := fmt.Sprintf("auth/%s/config", path)
+		config, err := client.Logical().Read(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if config != nil {
+			resources = append(resources, stateResourceConfig{
+				Path: configPath,
+				Data: redactSensitiveFields(config.Data, strings.ReplaceAll(path, "/", "-")+"-config"),
+			})
+		}
+	}
+
+	rolePath, ok := exportAuthRolePaths[authType]
+	if !ok {
+		log.Warnf("Export: Roles of auth method %s (%s) are not exported", path, authType)
+		return resources, nil
+	}
+	list, err := client.Logical().List(fmt.Sprintf("auth/%s/%s", path, rolePath))
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		return resources, nil
+	}
+	names := toStrings(list.Data["keys"])
+	sort.Strings(names)
+	for _, name := range names {
+		path := fmt.Sprintf("auth/%s/%s/%s", path, rolePath, name)
+		role, err := client.Logical().Read(path)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		data := role.Data
+		for deprecated, current := range exportDeprecatedFields {
+			if _, ok := data[current]; ok {
+				delete(data, deprecated)
+			}
+		}
+		for _, field := range exportWriteOnlyFields[authType] {
+			data[field] = ""
+		}
+		resources = append(resources, stateResourceConfig{
+			Path: path,
+			Data: redactSensitiveFields(data, fmt.Sprintf("%s-%s", authType, name)),
+		})
+	}
+	return resources, nil
+}
+
+// redactSensitiveFields replaces the values of the sensitive fields with placeholders
+// referencing the key of the same name in the K8s secret secretName
+func redactSensitiveFields(data map[string]interface{}, secretName string) map[string]interface{} {
+	for key := range data {
+		if containsString(sensitiveFields, key) {
+			data[key] = fmt.Sprintf("${k8sSecret:%s/%s}", secretName, key)
+		}
+	}
+	return data
+}
+
+func sortedMountPaths(m interface{}) []string {
+	var paths []string
+	switch typed := m.(type) {
+	case map[string]*vault.MountOutput:
+		for k := range typed {
+			paths = append(paths, k)
+		}
+	case map[string]*vault.Audit:
+		for k := range typed {
+			paths = append(paths, k)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
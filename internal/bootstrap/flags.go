@@ -0,0 +1,83 @@
+package bootstrap
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+)
+
+// Command line flags mirror the environment variables. The values are only recorded
+// while parsing and are resolved with the other sources by LoadSettings
+
+// settingFlag is the flag of a setting
+type settingFlag struct {
+	source *settingSource
+}
+
+func (f *settingFlag) String() string {
+	if f == nil || f.source == nil {
+		return ""
+	}
+	if value, ok := settingFlagValues[f.source.flag]; ok {
+		return value
+	}
+	defaults := defaultSettings()
+	return fmt.Sprint(reflect.ValueOf(f.source.field(&defaults)).Elem().Interface())
+}
+
+func (f *settingFlag) Set(value string) error {
+	var check settings
+	if err := setSetting(f.source.field(&check), value); err != nil {
+		return err
+	}
+	settingFlagValues[f.source.flag] = value
+	return nil
+}
+
+// IsBoolFlag allows boolean settings to be set without value, e.g. --pki
+func (f *settingFlag) IsBoolFlag() bool {
+	var check settings
+	_, ok := f.source.field(&check).(*bool)
+	return ok
+}
+
+func addSettingFlags(fs *flag.FlagSet, group int) {
+	for i := range settingSources {
+		source := &settingSources[i]
+		for _, g := range source.groups {
+			if g == group {
+				fs.Var(&settingFlag{source}, source.flag, fmt.Sprintf("%s (%s)", source.usage, source.env))
+			}
+		}
+	}
+}
+
+// AddVaultFlags adds the flags locating the Vault cluster and its key store
+func AddVaultFlags(fs *flag.FlagSet) {
+	addSettingFlags(fs, settingsVault)
+}
+
+// AddInitFlags adds the flags of the initialization step
+func AddInitFlags(fs *flag.FlagSet) {
+	addSettingFlags(fs, settingsInit)
+}
+
+// AddConfigureFlags adds the flags selecting the configuration steps
+func AddConfigureFlags(fs *flag.FlagSet) {
+	addSettingFlags(fs, settingsConfigure)
+}
+
+// AddStateFlags adds the flags of the desired state commands
+func AddStateFlags(fs *flag.FlagSet) {
+	addSettingFlags(fs, settingsState)
+}
+
+// AddDriftFlags adds the flags of the drift command
+func AddDriftFlags(fs *flag.FlagSet) {
+	addSettingFlags(fs, settingsDrift)
+}
+
+// AddInitContainerFlags adds the flags of the init-container command
+func AddInitContainerFlags(fs *flag.FlagSet) {
+	addSettingFlags(fs, settingsInitContainer)
+}
@@ -0,0 +1,292 @@
+package bootstrap
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotations of the root token secret written by generate-root
+const (
+	RootTokenTTLAnnotation       = "vault-bootstrap/token-ttl"
+	RootTokenExpiresAtAnnotation = "vault-bootstrap/token-expires-at"
+)
+
+// Characters of the OTP expected by Vault 1.0 and later
+const otpCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// generatedRootToken is used by Run instead of the stored root token for a single configuration run
+var generatedRootToken *string
+
+// GenerateRoot generates a new root token by submitting the stored unseal keys to sys/generate-root.
+// The token is printed, replaced by a root token expiring in Vault after ttl stored in the root
+// token secret, or used for a single run of the configuration steps and then revoked
+func GenerateRoot(store bool, ttl time.Duration, configure bool) int {
+	if store && configure {
+		log.Error("Generate root: --store and --configure are mutually exclusive")
+		return ExitError
+	}
+	if store && ttl <= 0 {
+		log.Error("Generate root: --ttl must be positive")
+		return ExitError
+	}
+	clientsetK8s, err := newK8sClientset()
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	clientConfig, err := newVaultLBConfig()
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	client, err := vault.NewClient(clientConfig)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	// sys/generate-root is unauthenticated, a VAULT_TOKEN from the environment is not needed
+	client.ClearToken()
+
+	token, err := generateRoot(client, clientsetK8s)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+
+	switch {
+	case store:
+		if err := storeRootToken(client, clientsetK8s, token, ttl); err != nil {
+			log.Error(err.Error())
+			return ExitError
+		}
+	case configure:
+		client.SetToken(token)
+		var once sync.Once
+		revoke := func() {
+			once.Do(func() {
+				if err := client.Auth().Token().RevokeSelf(""); err != nil {
+					log.Errorf("Generate root: Cannot revoke the generated root token - %s. Revoke it with 'vault token revoke -self'", err.Error())
+					return
+				}
+				log.Info("Generate root: Generated root token revoked")
+			})
+		}
+		// The configuration steps exit on errors, which must not leave the token valid
+		onExit(revoke)
+		defer revoke()
+		generatedRootToken = &token
+		RunConfigure()
+	default:
+		fmt.Println(token)
+	}
+	return ExitOK
+}
+
+func generateRoot(client *vault.Client, clientsetK8s *kubernetes.Clientset) (string, error) {
+	status, err := client.Sys().GenerateRootStatus()
+	if err != nil {
+		return "", fmt.Errorf("Generate root: %s", err.Error())
+	}
+	if status.Started {
+		return "", fmt.Errorf("Generate root: An attempt is already in progress (nonce %s). Cancel it with 'vault operator generate-root -cancel'", status.Nonce)
+	}
+
+	unsealKeysString, err := getValuesFromK8sSecret(clientsetK8s, &vaultSecretUnseal)
+	if err != nil {
+		return "", fmt.Errorf("Generate root: Cannot load Unseal Keys - %s", err.Error())
+	}
+
+	otp, err := generateOTP(status.OTPLength)
+	if err != nil {
+		return "", fmt.Errorf("Generate root: Cannot generate the OTP - %s", err.Error())
+	}
+	status, err = client.Sys().GenerateRootInit(otp, "")
+	if err != nil {
+		return "", fmt.Errorf("Generate root: %s", err.Error())
+	}
+	nonce := status.Nonce
+	log.Infof("Generate root: Started with nonce %s", nonce)
+
+	for _, key := range strings.Split(*unsealKeysString, ";") {
+		if status, err = client.Sys().GenerateRootUpdate(key, nonce); err != nil {
+			return "", cancelGenerateRoot(client, fmt.Errorf("Generate root: %s", err.Error()))
+		}
+		if status.Nonce != nonce {
+			return "", cancelGenerateRoot(client, fmt.Errorf("Generate root: Nonce changed from %s to %s. Another attempt may be in progress", nonce, status.Nonce))
+		}
+		if status.Complete {
+			break
+		}
+	}
+	if !status.Complete {
+		return "", cancelGenerateRoot(client, fmt.Errorf("Generate root: Not enough Unseal Keys stored to reach the threshold of %d", status.Required))
+	}
+
+	encodedToken := status.EncodedToken
+	if encodedToken == "" {
+		encodedToken = status.EncodedRootToken
+	}
+	token, err := decodeRootToken(encodedToken, otp)
+	if err != nil {
+		return "", fmt.Errorf("Generate root: Cannot decode the root token - %s", err.Error())
+	}
+	log.Info("Generate root: Root token generated")
+	return token, nil
+}
+
+// generateOTP returns an OTP of length characters, or a base64 encoded 16 bytes one
+// for Vault versions before 1.0, which don't report the OTP length
+func generateOTP(length int) (string, error) {
+	if length == 0 {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(buf), nil
+	}
+	otp := make([]byte, length)
+	for i := range otp {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(otpCharset))))
+		if err != nil {
+			return "", err
+		}
+		otp[i] = otpCharset[n.Int64()]
+	}
+	return string(otp), nil
+}
+
+// decodeRootToken XORs the encoded token with the OTP, as 'vault operator generate-root -decode' does
+func decodeRootToken(encodedToken string, otp string) (string, error) {
+	tokenBytes, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encodedToken, "="))
+	if err != nil {
+		return "", err
+	}
+	// Before Vault 1.0 the OTP is base64 encoded and the token is a UUID
+	if otpBytes, err := base64.StdEncoding.DecodeString(otp); err == nil && len(otpBytes) == 16 && len(tokenBytes) == 16 {
+		uuid := xorBytes(tokenBytes, otpBytes)
+		return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
+	}
+	if len(tokenBytes) != len(otp) {
+		return "", fmt.Errorf("token length %d differs from OTP length %d", len(tokenBytes), len(otp))
+	}
+	return string(xorBytes(tokenBytes, []byte(otp))), nil
+}
+
+func xorBytes(a []byte, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
+
+// cancelGenerateRoot cancels the attempt in progress and returns err
+func cancelGenerateRoot(client *vault.Client, err error) error {
+	if cancelErr := client.Sys().GenerateRootCancel(); cancelErr != nil {
+		return fmt.Errorf("%s. Cannot cancel the attempt - %s", err.Error(), cancelErr.Error())
+	}
+	log.Warn("Generate root: Attempt cancelled")
+	return err
+}
+
+// storeRootToken stores in the root token secret a root token created with ttl from the generated one,
+// so that Vault itself expires it, then revokes the generated token. The token previously stored
+// is revoked first, as it would otherwise stay valid without being stored anywhere
+func storeRootToken(client *vault.Client, clientsetK8s *kubernetes.Clientset, token string, ttl time.Duration) error {
+	client.SetToken(token)
+	defer func() {
+		client.SetToken(token)
+		if err := client.Auth().Token().RevokeSelf(""); err != nil {
+			log.Errorf("Generate root: Cannot revoke the generated root token - %s. Revoke it with 'vault token revoke -self'", err.Error())
+			return
+		}
+		log.Info("Generate root: Generated root token revoked")
+	}()
+
+	if err := revokeStoredRootToken(client, clientsetK8s); err != nil {
+		return err
+	}
+
+	// An orphan, as revoking the generated token revokes its children
+	renewable := false
+	secret, err := client.Auth().Token().CreateOrphan(&vault.TokenCreateRequest{
+		Policies:       []string{"root"},
+		TTL:            ttl.String(),
+		ExplicitMaxTTL: ttl.String(),
+		Renewable:      &renewable,
+		DisplayName:    "vault-bootstrap",
+	})
+	if err != nil {
+		return fmt.Errorf("Generate root: Cannot create the root token to store - %s", err.Error())
+	}
+	stored := secret.Auth.ClientToken
+
+	expiresAt := time.Now().Add(ttl).UTC().Format(time.RFC3339)
+	if err := applyK8sSecretWithAnnotations(clientsetK8s, vaultSecretRoot, map[string]string{"vaultData": stored}, map[string]string{
+		RootTokenTTLAnnotation:       ttl.String(),
+		RootTokenExpiresAtAnnotation: expiresAt,
+	}); err != nil {
+		if revokeErr := client.Auth().Token().RevokeOrphan(stored); revokeErr != nil {
+			log.Errorf("Generate root: Cannot revoke the root token which was not stored - %s", revokeErr.Error())
+		}
+		return fmt.Errorf("Generate root: Cannot store the root token - %s", err.Error())
+	}
+	log.Infof("Generate root: Root token stored in secret %s. Vault expires it at %s", vaultSecretRoot, expiresAt)
+	return nil
+}
+
+// revokeStoredRootToken revokes the token of the root token secret if Vault still accepts it.
+// The tokens it created are kept
+func revokeStoredRootToken(client *vault.Client, clientsetK8s *kubernetes.Clientset) error {
+	previous, err := getValuesFromK8sSecret(clientsetK8s, &vaultSecretRoot)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Generate root: %s", err.Error())
+	}
+	if *previous == "" {
+		return nil
+	}
+	if _, err := client.Auth().Token().Lookup(*previous); err != nil {
+		log.Debugf("Generate root: The root token of secret %s is no longer valid - %s", vaultSecretRoot, err.Error())
+		return nil
+	}
+	if err := client.Auth().Token().RevokeOrphan(*previous); err != nil {
+		return fmt.Errorf("Generate root: Cannot revoke the root token of secret %s before replacing it - %s", vaultSecretRoot, err.Error())
+	}
+	log.Infof("Generate root: Previous root token of secret %s revoked", vaultSecretRoot)
+	return nil
+}
+
+// loadRootToken loads the root token from the key store. A token stored by generate-root
+// past its expiry annotation, which Vault no longer accepts, is reported instead of being used
+func loadRootToken(clientsetK8s *kubernetes.Clientset) (*string, error) {
+	secret, err := getK8sSecret(clientsetK8s, vaultSecretRoot)
+	if err != nil {
+		return nil, err
+	}
+	rootToken := string(secret.Data["vaultData"])
+	expiresAt, ok := secret.Annotations[RootTokenExpiresAtAnnotation]
+	if !ok {
+		return &rootToken, nil
+	}
+	expiry, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s annotation of secret %s - %s", RootTokenExpiresAtAnnotation, vaultSecretRoot, err.Error())
+	}
+	if time.Now().Before(expiry) {
+		return &rootToken, nil
+	}
+	return nil, fmt.Errorf("Root token of secret %s expired at %s. Generate a new one with 'vault-bootstrap generate-root'", vaultSecretRoot, expiresAt)
+}
@@ -0,0 +1,59 @@
+package bootstrap
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestGenerateOTP(t *testing.T) {
+	for _, length := range []int{24, 26, 28} {
+		otp, err := generateOTP(length)
+		if err != nil {
+			t.Fatalf("generateOTP(%d): %s", length, err)
+		}
+		if len(otp) != length || strings.Trim(otp, otpCharset) != "" {
+			t.Fatalf("generateOTP(%d) = %q", length, otp)
+		}
+	}
+	// Vault versions before 1.0 report no OTP length and expect a base64 encoded 16 bytes OTP
+	otp, err := generateOTP(0)
+	if err != nil {
+		t.Fatalf("generateOTP(0): %s", err)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(otp); err != nil || len(decoded) != 16 {
+		t.Fatalf("generateOTP(0) = %q", otp)
+	}
+}
+
+func TestDecodeRootToken(t *testing.T) {
+	// Vault 1.0 and later: the token XORed with the OTP, base64 encoded without padding
+	token := "s.K0yGgVm1SAZfiIp3vkK3oRfQ"
+	otp := "mJ5uHcZyQXWbYkVR8ZpXfSzN2x"
+	encoded := base64.RawStdEncoding.EncodeToString(xorBytes([]byte(token), []byte(otp)))
+	// Depending on the version, Vault returns it with or without padding
+	for _, e := range []string{encoded, base64.StdEncoding.EncodeToString(xorBytes([]byte(token), []byte(otp)))} {
+		decoded, err := decodeRootToken(e, otp)
+		if err != nil || decoded != token {
+			t.Fatalf("decodeRootToken(%q) = %q, %v, want %q", e, decoded, err, token)
+		}
+	}
+
+	// Before Vault 1.0: a UUID XORed with a base64 encoded OTP
+	uuid := "8f2c1a7e-4b3d-4e6f-9a0b-1c2d3e4f5a6b"
+	uuidBytes, _ := hex.DecodeString(strings.Replace(uuid, "-", "", -1))
+	otpBytes := []byte("0123456789abcdef")
+	legacyOTP := base64.StdEncoding.EncodeToString(otpBytes)
+	decoded, err := decodeRootToken(base64.StdEncoding.EncodeToString(xorBytes(uuidBytes, otpBytes)), legacyOTP)
+	if err != nil || decoded != uuid {
+		t.Fatalf("decodeRootToken legacy = %q, %v, want %q", decoded, err, uuid)
+	}
+
+	if _, err := decodeRootToken(encoded, otp[:10]); err == nil {
+		t.Fatal("expected an error for an OTP of another length")
+	}
+	if _, err := decodeRootToken("not base64!", otp); err == nil {
+		t.Fatal("expected an error for an invalid encoding")
+	}
+}
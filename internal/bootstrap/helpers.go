@@ -1,7 +1,14 @@
 package bootstrap
 
 import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
 	apiv1 "k8s.io/api/core/v1"
 )
 
@@ -16,9 +23,81 @@ func find(slice []int, val int) bool {
 	return false
 }
 
+func containsString(slice []string, val string) bool {
+	for _, item := range slice {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
+
 func getPodName(p *apiv1.Pod) string {
 	if p.ObjectMeta.Name != "" {
 		return p.ObjectMeta.Name
 	}
 	return strings.TrimSuffix(p.ObjectMeta.GenerateName, "-")
 }
+
+const passwordCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// generatePassword returns a random alphanumeric string using crypto/rand
+func generatePassword(length int) (string, error) {
+	password := make([]byte, length)
+	max := big.NewInt(int64(len(passwordCharset)))
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = passwordCharset[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// parseTTL converts a TTL given either as seconds or as a Go duration (e.g. 1h) to seconds
+func parseTTL(ttl string) (int, error) {
+	if seconds, err := strconv.Atoi(ttl); err == nil {
+		return seconds, nil
+	}
+	duration, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, err
+	}
+	return int(duration.Seconds()), nil
+}
+
+// toStrings converts a list decoded from a Vault response to a slice of strings
+func toStrings(value interface{}) []string {
+	var result []string
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			result = append(result, fmt.Sprint(item))
+		}
+	}
+	return result
+}
+
+// equalStrings compares two slices of strings, regardless of the order
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setDefaultString sets value to defaultValue when not configured
+func setDefaultString(value *string, defaultValue string) {
+	if *value == "" {
+		*value = defaultValue
+	}
+}
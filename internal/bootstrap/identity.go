@@ -0,0 +1,271 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	identityGroupInternal = "internal"
+	identityGroupExternal = "external"
+)
+
+type identityConfig struct {
+	Entities []identityEntityConfig `json:"entities"`
+	Groups   []identityGroupConfig  `json:"groups"`
+}
+
+type identityEntityConfig struct {
+	Name     string                `json:"name"`
+	Policies []string              `json:"policies"`
+	Metadata map[string]string     `json:"metadata"`
+	Disabled bool                  `json:"disabled"`
+	Aliases  []identityAliasConfig `json:"aliases"`
+}
+
+type identityGroupConfig struct {
+	Name string `json:"name"`
+	// internal (default) or external
+	Type     string            `json:"type"`
+	Policies []string          `json:"policies"`
+	Metadata map[string]string `json:"metadata"`
+	// Names of the member entities and groups. Internal groups only
+	MemberEntities []string `json:"memberEntities"`
+	MemberGroups   []string `json:"memberGroups"`
+	// Links an external group to a group of an auth method, e.g. an OIDC group claim value
+	Alias *identityAliasConfig `json:"alias"`
+}
+
+type identityAliasConfig struct {
+	// Path of the auth method, e.g. kubernetes or oidc
+	Mount string `json:"mount"`
+	// Name as known by the auth method, e.g. the service account name for Kubernetes or the user name for userpass
+	Name string `json:"name"`
+}
+
+func configureIdentity(client *vault.Client, config identityConfig) error {
+	auths, err := client.Sys().ListAuth()
+	if err != nil {
+		return fmt.Errorf("Identity: %s", err.Error())
+	}
+
+	entityIDs := map[string]string{}
+	for _, entity := range config.Entities {
+		id, err := configureIdentityEntity(client, auths, entity)
+		if err != nil {
+			return fmt.Errorf("Identity: entity %s: %s", entity.Name, err.Error())
+		}
+		entityIDs[entity.Name] = id
+	}
+
+	// Groups are created first, so that they can be referenced as members of each other
+	groupIDs := map[string]string{}
+	for _, group := range config.Groups {
+		id, err := configureIdentityGroup(client, auths, group, entityIDs)
+		if err != nil {
+			return fmt.Errorf("Identity: group %s: %s", group.Name, err.Error())
+		}
+		groupIDs[group.Name] = id
+	}
+	for _, group := range config.Groups {
+		if err := configureIdentityGroupMembers(client, group, groupIDs); err != nil {
+			return fmt.Errorf("Identity: group %s: %s", group.Name, err.Error())
+		}
+	}
+	log.Info("Identity: Successfully configured")
+	return nil
+}
+
+// configureIdentityEntity creates or updates the entity and its aliases and returns its ID
+func configureIdentityEntity(client *vault.Client, auths map[string]*vault.AuthMount, entity identityEntityConfig) (string, error) {
+	if entity.Name == "" {
+		return "", fmt.Errorf("name is mandatory")
+	}
+	entityPath := "identity/entity/name/" + entity.Name
+	if _, err := client.Logical().Write(entityPath, map[string]interface{}{
+		"policies": entity.Policies,
+		"metadata": entity.Metadata,
+		"disabled": entity.Disabled,
+	}); err != nil {
+		return "", err
+	}
+	current, err := client.Logical().Read(entityPath)
+	if err != nil {
+		return "", err
+	}
+	if current == nil {
+		return "", fmt.Errorf("cannot read entity after creation")
+	}
+	id := fmt.Sprint(current.Data["id"])
+	log.Infof("Identity: Entity %s configured", entity.Name)
+
+	// Current aliases of the entity, by mount accessor
+	aliases := map[string]map[string]interface{}{}
+	if list, ok := current.Data["aliases"].([]interface{}); ok {
+		for _, item := range list {
+			if alias, ok := item.(map[string]interface{}); ok {
+				aliases[fmt.Sprint(alias["mount_accessor"])] = alias
+			}
+		}
+	}
+	for _, alias := range entity.Aliases {
+		accessor, err := getAuthAccessor(auths, alias.Mount)
+		if err != nil {
+			return "", err
+		}
+		if alias.Name == "" {
+			return "", fmt.Errorf("alias name is mandatory")
+		}
+		if err := writeIdentityAlias(client, "entity-alias", aliases[accessor], id, accessor, alias); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+// configureIdentityGroup creates or updates the group, its member entities and its alias and returns its ID
+func configureIdentityGroup(client *vault.Client, auths map[string]*vault.AuthMount, group identityGroupConfig, entityIDs map[string]string) (string, error) {
+	if group.Name == "" {
+		return "", fmt.Errorf("name is mandatory")
+	}
+	groupType := group.Type
+	if groupType == "" {
+		groupType = identityGroupInternal
+	}
+	if groupType != identityGroupInternal && groupType != identityGroupExternal {
+		return "", fmt.Errorf("type must be %s or %s", identityGroupInternal, identityGroupExternal)
+	}
+	groupPath := "identity/group/name/" + group.Name
+
+	current, err := client.Logical().Read(groupPath)
+	if err != nil {
+		return "", err
+	}
+	if current != nil && fmt.Sprint(current.Data["type"]) != groupType {
+		return "", fmt.Errorf("group already exists with type %s instead of %s", current.Data["type"], groupType)
+	}
+
+	data := map[string]interface{}{
+		"type":     groupType,
+		"policies": group.Policies,
+		"metadata": group.Metadata,
+	}
+	if groupType == identityGroupInternal {
+		if group.Alias != nil {
+			return "", fmt.Errorf("alias can only be set on external groups")
+		}
+		memberEntityIDs := []string{}
+		for _, name := range group.MemberEntities {
+			id, err := getIdentityID(client, "entity", name, entityIDs)
+			if err != nil {
+				return "", err
+			}
+			memberEntityIDs = append(memberEntityIDs, id)
+		}
+		data["member_entity_ids"] = memberEntityIDs
+	} else if len(group.MemberEntities) > 0 || len(group.MemberGroups) > 0 {
+		return "", fmt.Errorf("members of external groups are managed by the auth method")
+	}
+
+	if _, err := client.Logical().Write(groupPath, data); err != nil {
+		return "", err
+	}
+	if current, err = client.Logical().Read(groupPath); err != nil {
+		return "", err
+	}
+	if current == nil {
+		return "", fmt.Errorf("cannot read group after creation")
+	}
+	id := fmt.Sprint(current.Data["id"])
+	log.Infof("Identity: Group %s configured", group.Name)
+
+	if group.Alias != nil {
+		accessor, err := getAuthAccessor(auths, group.Alias.Mount)
+		if err != nil {
+			return "", err
+		}
+		if group.Alias.Name == "" {
+			return "", fmt.Errorf("alias name is mandatory")
+		}
+		currentAlias, _ := current.Data["alias"].(map[string]interface{})
+		if err := writeIdentityAlias(client, "group-alias", currentAlias, id, accessor, *group.Alias); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+// configureIdentityGroupMembers replaces the member groups of an internal group
+func configureIdentityGroupMembers(client *vault.Client, group identityGroupConfig, groupIDs map[string]string) error {
+	if group.Type == identityGroupExternal {
+		return nil
+	}
+	memberGroupIDs := []string{}
+	for _, name := range group.MemberGroups {
+		id, err := getIdentityID(client, "group", name, groupIDs)
+		if err != nil {
+			return err
+		}
+		memberGroupIDs = append(memberGroupIDs, id)
+	}
+	_, err := client.Logical().Write("identity/group/name/"+group.Name, map[string]interface{}{
+		"member_group_ids": memberGroupIDs,
+	})
+	return err
+}
+
+// writeIdentityAlias creates the alias or renames the current one if it changed
+// current is the alias of the entity or group on the same mount, if any
+func writeIdentityAlias(client *vault.Client, kind string, current map[string]interface{}, canonicalID string, accessor string, alias identityAliasConfig) error {
+	if current != nil && current["id"] != nil && fmt.Sprint(current["mount_accessor"]) == accessor {
+		if fmt.Sprint(current["name"]) == alias.Name {
+			log.Debugf("Identity: Alias %s on %s up to date", alias.Name, alias.Mount)
+			return nil
+		}
+		if _, err := client.Logical().Write(fmt.Sprintf("identity/%s/id/%s", kind, current["id"]), map[string]interface{}{
+			"name":           alias.Name,
+			"mount_accessor": accessor,
+			"canonical_id":   canonicalID,
+		}); err != nil {
+			return err
+		}
+		log.Infof("Identity: Alias %s on %s updated", alias.Name, alias.Mount)
+		return nil
+	}
+	if _, err := client.Logical().Write("identity/"+kind, map[string]interface{}{
+		"name":           alias.Name,
+		"mount_accessor": accessor,
+		"canonical_id":   canonicalID,
+	}); err != nil {
+		return err
+	}
+	log.Infof("Identity: Alias %s on %s created", alias.Name, alias.Mount)
+	return nil
+}
+
+func getAuthAccessor(auths map[string]*vault.AuthMount, mount string) (string, error) {
+	path := strings.Trim(mount, "/") + "/"
+	auth, ok := auths[path]
+	if !ok {
+		return "", fmt.Errorf("auth method %s is not enabled", path)
+	}
+	return auth.Accessor, nil
+}
+
+// getIdentityID returns the ID of an entity or group, either configured in this run or already existing
+func getIdentityID(client *vault.Client, kind string, name string, known map[string]string) (string, error) {
+	if id, ok := known[name]; ok {
+		return id, nil
+	}
+	resp, err := client.Logical().Read(fmt.Sprintf("identity/%s/name/%s", kind, name))
+	if err != nil {
+		return "", err
+	}
+	if resp == nil {
+		return "", fmt.Errorf("%s %s does not exist", kind, name)
+	}
+	return fmt.Sprint(resp.Data["id"]), nil
+}
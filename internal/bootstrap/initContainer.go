@@ -11,32 +11,21 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 var vaultInitContainerImage string
-var vaultJobImage string
 
 func InitContainer() {
 	// Create clientSet for k8s client-go
-	k8sConfig, err := rest.InClusterConfig()
-	if err != nil {
-		log.Error(err.Error())
-		os.Exit(1)
-	}
-
-	//k8sConfig, _ := clientcmd.BuildConfigFromFlags("", os.Getenv("HOME")+"/.kube/config")
-
-	clientsetK8s, err := kubernetes.NewForConfig(k8sConfig)
+	clientsetK8s, err := newK8sClientset()
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
 
 	// Define Parameters
-	podName, ok := os.LookupEnv("VAULT_K8S_POD_NAME")
-	if !ok {
+	podName := vaultPodName
+	if podName == "" {
 		panic("Cannot extract Pod name from environment variables")
 	}
 	pod, err := clientsetK8s.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
@@ -50,15 +39,13 @@ func InitContainer() {
 
 	vaultInitContainerImage = pod.Status.InitContainerStatuses[0].Image
 	// Get Vault image from env variables (if defined)
-	if extrVaultJobImage, ok := os.LookupEnv("VAULT_JOB_IMAGE"); !ok {
+	if vaultJobImage == "" {
 		log.Warn("VAULT_JOB_IMAGE is not set. Defaulting to ", vaultInitContainerImage)
 		vaultJobImage = vaultInitContainerImage
-	} else {
-		vaultJobImage = extrVaultJobImage
 	}
 
-	namespace, ok := os.LookupEnv("VAULT_K8S_NAMESPACE")
-	if !ok {
+	namespace := vaultPodNamespace
+	if namespace == "" {
 		panic("Cannot extract Namespace name from environment variables")
 	}
 
@@ -0,0 +1,182 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	jose "gopkg.in/square/go-jose.v2"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultJwtAuthPath = "jwt"
+	// Validate tokens using the OIDC discovery of the service account issuer
+	JwtAuthModeDiscovery = "discovery"
+	// Validate tokens using the public keys fetched from the K8s API (private clusters)
+	JwtAuthModeJwks = "jwks"
+)
+
+type jwtAuthConfig struct {
+	Path string          `json:"path"`
+	Tune authMountConfig `json:"tune"`
+	// discovery (default) or jwks
+	Mode string `json:"mode"`
+	// Overrides the issuer advertised by the K8s API
+	Issuer string `json:"issuer"`
+	// CA used by Vault to reach the discovery URL. Defaults to the K8s API CA
+	DiscoveryCAPEM string        `json:"discoveryCAPEM"`
+	Roles          []jwtAuthRole `json:"roles"`
+}
+
+type jwtAuthRole struct {
+	Name           string            `json:"name"`
+	BoundAudiences []string          `json:"boundAudiences"`
+	BoundSubject   string            `json:"boundSubject"`
+	BoundClaims    map[string]string `json:"boundClaims"`
+	ClaimMappings  map[string]string `json:"claimMappings"`
+	// Defaults to sub
+	UserClaim   string   `json:"userClaim"`
+	Policies    []string `json:"policies"`
+	TokenTTL    string   `json:"tokenTTL"`
+	TokenMaxTTL string   `json:"tokenMaxTTL"`
+}
+
+// Subset of the OIDC discovery document published by the K8s API
+type oidcDiscovery struct {
+	Issuer string `json:"issuer"`
+}
+
+func configureJwtAuth(client *vault.Client, clientsetK8s *kubernetes.Clientset, config jwtAuthConfig) error {
+	path := config.Path
+	if path == "" {
+		path = DefaultJwtAuthPath
+	}
+	mode := config.Mode
+	if mode == "" {
+		mode = JwtAuthModeDiscovery
+	}
+
+	issuer := config.Issuer
+	if issuer == "" {
+		discovery, err := getServiceAccountIssuer(clientsetK8s)
+		if err != nil {
+			return fmt.Errorf("JWT authentication: Can't get service account issuer - %s", err.Error())
+		}
+		issuer = discovery.Issuer
+	}
+	log.Info("JWT authentication: Service account issuer: ", issuer)
+
+	data := map[string]interface{}{
+		"bound_issuer": issuer,
+	}
+	switch mode {
+	case JwtAuthModeDiscovery:
+		caPEM := config.DiscoveryCAPEM
+		if caPEM == "" {
+			cacert, err := getK8sAPICA()
+			if err != nil {
+				return err
+			}
+			caPEM = cacert
+		}
+		data["oidc_discovery_url"] = issuer
+		data["oidc_discovery_ca_pem"] = caPEM
+	case JwtAuthModeJwks:
+		pubKeys, err := getServiceAccountPublicKeys(clientsetK8s)
+		if err != nil {
+			return fmt.Errorf("JWT authentication: Can't get service account public keys - %s", err.Error())
+		}
+		data["jwt_validation_pubkeys"] = pubKeys
+	default:
+		return fmt.Errorf("JWT authentication: Invalid mode %s. Must be '%s' or '%s'", mode, JwtAuthModeDiscovery, JwtAuthModeJwks)
+	}
+
+	if err := enableAuth(client, path, "jwt", config.Tune); err != nil {
+		return fmt.Errorf("JWT authentication: %s", err.Error())
+	}
+	if _, err := client.Logical().Write(fmt.Sprintf("auth/%s/config", path), data); err != nil {
+		return fmt.Errorf("JWT authentication: %s", err.Error())
+	}
+
+	for _, role := range config.Roles {
+		if err := configureJwtAuthRole(client, path, role); err != nil {
+			return fmt.Errorf("JWT authentication: role %s: %s", role.Name, err.Error())
+		}
+	}
+	log.Info("JWT authentication: Successfully configured")
+	return nil
+}
+
+func configureJwtAuthRole(client *vault.Client, path string, role jwtAuthRole) error {
+	if role.Name == "" {
+		return fmt.Errorf("name is mandatory")
+	}
+	userClaim := role.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	data := map[string]interface{}{
+		"role_type":       "jwt",
+		"bound_audiences": role.BoundAudiences,
+		"bound_subject":   role.BoundSubject,
+		"bound_claims":    role.BoundClaims,
+		"claim_mappings":  role.ClaimMappings,
+		"user_claim":      userClaim,
+		"token_policies":  role.Policies,
+		"token_ttl":       role.TokenTTL,
+		"token_max_ttl":   role.TokenMaxTTL,
+	}
+	if _, err := client.Logical().Write(fmt.Sprintf("auth/%s/role/%s", path, role.Name), data); err != nil {
+		return err
+	}
+	log.Infof("JWT authentication: Role %s configured", role.Name)
+	return nil
+}
+
+// Read the OIDC discovery document of the service account issuer from the K8s API
+func getServiceAccountIssuer(clientsetK8s *kubernetes.Clientset) (*oidcDiscovery, error) {
+	raw, err := clientsetK8s.CoreV1().RESTClient().Get().AbsPath("/.well-known/openid-configuration").DoRaw(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	discovery := &oidcDiscovery{}
+	if err := json.Unmarshal(raw, discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer == "" {
+		return nil, fmt.Errorf("issuer missing from discovery document")
+	}
+	return discovery, nil
+}
+
+// Fetch the JWKS of the service account issuer from the K8s API and convert the keys to PEM
+func getServiceAccountPublicKeys(clientsetK8s *kubernetes.Clientset) ([]string, error) {
+	raw, err := clientsetK8s.CoreV1().RESTClient().Get().AbsPath("/openid/v1/jwks").DoRaw(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	jwks := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(raw, &jwks); err != nil {
+		return nil, err
+	}
+	var pubKeys []string
+	for _, key := range jwks.Keys {
+		der, err := x509.MarshalPKIXPublicKey(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", key.KeyID, err.Error())
+		}
+		pubKeys = append(pubKeys, string(pem.EncodeToMemory(&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: der,
+		})))
+	}
+	if len(pubKeys) == 0 {
+		return nil, fmt.Errorf("no keys found in JWKS")
+	}
+	return pubKeys, nil
+}
@@ -4,25 +4,33 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"time"
 
 	vault "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
+// Suffix of the token secret created for the vault service account
+const ServiceAccountTokenSuffix = "-token"
+
+type k8sAuthConfig struct {
+	Tune authMountConfig `json:"tune"`
+}
+
 func checkVaultUp(client *vault.Client) (bool) {
 	for i := 0; i < 5; i++ {
 		hr, err := client.Sys().Health()
 		if err != nil {
-			log.Warn(err.Error(), "K8s authentication: Retrying in 3 seconds...")
+			log.Warnf("Vault health check: %s. Retrying in 3 seconds...", err.Error())
 			time.Sleep(3 * time.Second)
 			continue
 		}
 		if !hr.Initialized || hr.Sealed {
-			log.Warn("K8s authentication: Vault not Initialized/Unsealed. Retrying ...")
+			log.Warn("Vault health check: Vault not Initialized/Unsealed. Retrying ...")
 			time.Sleep(3 * time.Second)
 			continue
 		}
@@ -40,58 +48,151 @@ func checkK8sAuth(client *vault.Client) (bool, error) {
 	}
 	return false, nil
 }
-func configureK8sAuth(client *vault.Client, clientsetK8s *kubernetes.Clientset) error {
 
-	// Enable K8S authentication
-	err := client.Sys().EnableAuthWithOptions("kubernetes/", &vault.EnableAuthOptions{
-		Type: "kubernetes",
-	})
+func configureK8sAuth(client *vault.Client, clientsetK8s *kubernetes.Clientset, config k8sAuthConfig) error {
 
+	// Enable K8S authentication
+	err := enableAuth(client, "kubernetes/", "kubernetes", config.Tune)
 	if err != nil {
 		return err
 	}
 
-	saClient := clientsetK8s.CoreV1().ServiceAccounts(namespace)
-	saClientVault, err := saClient.Get(context.TODO(), vaultServiceAccount, metav1.GetOptions{})
+	k8sApiUrl, cacert, vaultJwt, err := getK8sAPIConfig(clientsetK8s)
 	if err != nil {
-		return fmt.Errorf("K8s authentication: Cant't get vault service account - ", err.Error())
+		return fmt.Errorf("K8s authentication: %s", err.Error())
 	}
 
-	secretSaVaultName := saClientVault.Secrets[0].Name
-	log.Info("Token secret for vault: ", secretSaVaultName)
+	// Prepare payload for configuring k8s authentication
+	data := map[string]interface{}{
+		"kubernetes_host":    k8sApiUrl,
+		"kubernetes_ca_cert": cacert,
+		"token_reviewer_jwt": vaultJwt,
+	}
 
-	secretSaVault, err := clientsetK8s.CoreV1().Secrets(namespace).Get(context.TODO(), secretSaVaultName, metav1.GetOptions{})
+	// Configure K8S authentication
+	_, err = client.Logical().Write("auth/kubernetes/config", data)
 	if err != nil {
-		return fmt.Errorf("K8s authentication: Cant't get secret for vault service account - ", err.Error())
+		return err
 	}
-	vaultJwt := secretSaVault.Data["token"]
+	log.Info("K8s authentication: Successfully enabled")
+	return nil
+}
 
-	// Fetch CA for connecting to K8S API
-	cacert, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+// getK8sAPIConfig returns the K8s API URL, its CA certificate and the token of the vault service account,
+// used by Vault to reach the K8s API
+func getK8sAPIConfig(clientsetK8s *kubernetes.Clientset) (string, string, string, error) {
+	vaultJwt, err := getServiceAccountToken(clientsetK8s)
 	if err != nil {
-		return err
+		return "", "", "", err
 	}
 
-	// Get K8S API URL
-	k8sApiHost, ok := os.LookupEnv("KUBERNETES_PORT_443_TCP_ADDR")
-	if !ok {
-		return fmt.Errorf("K8s authentication: Invalid Kubernetes API config")
+	// K8s API URL and CA as used by this tool, in a pod or from kubeconfig
+	k8sConfig, err := newK8sConfig()
+	if err != nil {
+		return "", "", "", err
+	}
+	cacert, err := getK8sAPICA()
+	if err != nil {
+		return "", "", "", err
 	}
 
-	k8sApiUrl := fmt.Sprintf("https://%s:443", k8sApiHost)
+	return getK8sHost(k8sConfig.Host), cacert, vaultJwt, nil
+}
 
-	// Prepare payload for configuring k8s authentication
-	data := map[string]interface{}{
-		"kubernetes_host":    k8sApiUrl,
-		"kubernetes_ca_cert": string(cacert),
-		"token_reviewer_jwt": string(vaultJwt),
+// getK8sHost returns the K8s API URL reachable by Vault. Out of the cluster, the kubeconfig
+// host (e.g. https://127.0.0.1:6443) can't be reached from the Vault pods
+func getK8sHost(host string) string {
+	if vaultK8sHost != "" {
+		return vaultK8sHost
 	}
+	if k8sInCluster {
+		return host
+	}
+	log.Infof("Not running in a pod. Vault reaches the K8s API at %s. Set VAULT_K8S_HOST to change it", DefaultK8sHostOutOfCluster)
+	return DefaultK8sHostOutOfCluster
+}
 
-	// Configure K8S authentication
-	_, err = client.Logical().Write("auth/kubernetes/config", data)
+// getServiceAccountToken returns the long-lived token of the vault service account. Since K8s 1.24
+// service accounts get no token secret, so a kubernetes.io/service-account-token secret is created
+func getServiceAccountToken(clientsetK8s *kubernetes.Clientset) (string, error) {
+	sa, err := clientsetK8s.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), vaultServiceAccount, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("Cant't get vault service account - %s", err.Error())
+	}
+
+	// Service accounts may also reference image pull secrets
+	secretName := ""
+	for _, ref := range sa.Secrets {
+		secret, err := getK8sSecret(clientsetK8s, ref.Name)
+		if err == nil && secret.Type == apiv1.SecretTypeServiceAccountToken {
+			secretName = ref.Name
+			break
+		}
+	}
+	if secretName == "" {
+		secretName = vaultServiceAccount + ServiceAccountTokenSuffix
+		if err := createServiceAccountTokenSecret(clientsetK8s, secretName); err != nil {
+			return "", fmt.Errorf("Cant't create token secret for vault service account - %s", err.Error())
+		}
+	}
+	log.Info("Token secret for vault: ", secretName)
+
+	// The token controller fills the token of a new secret asynchronously
+	for i := 0; i < 10; i++ {
+		secret, err := getK8sSecret(clientsetK8s, secretName)
+		if err != nil {
+			return "", fmt.Errorf("Cant't get secret for vault service account - %s", err.Error())
+		}
+		if token := secret.Data[apiv1.ServiceAccountTokenKey]; len(token) > 0 {
+			return string(token), nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return "", fmt.Errorf("Token secret %s of vault service account not populated", secretName)
+}
+
+func createServiceAccountTokenSecret(clientsetK8s *kubernetes.Clientset, secretName string) error {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        secretName,
+			Annotations: map[string]string{apiv1.ServiceAccountNameKey: vaultServiceAccount},
+		},
+		Type: apiv1.SecretTypeServiceAccountToken,
+	}
+	_, err := clientsetK8s.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		existing, err := getK8sSecret(clientsetK8s, secretName)
+		if err != nil {
+			return err
+		}
+		if existing.Type != apiv1.SecretTypeServiceAccountToken || existing.Annotations[apiv1.ServiceAccountNameKey] != vaultServiceAccount {
+			return fmt.Errorf("K8s secret %s exists and is not a token secret of service account %s", secretName, vaultServiceAccount)
+		}
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	log.Info("K8s authentication: Successfully enabled")
+	log.Info("Created K8s secret ", secretName)
 	return nil
 }
+
+// getK8sAPICA returns the CA certificate of the K8s API
+func getK8sAPICA() (string, error) {
+	k8sConfig, err := newK8sConfig()
+	if err != nil {
+		return "", err
+	}
+	if len(k8sConfig.CAData) > 0 {
+		return string(k8sConfig.CAData), nil
+	}
+	if k8sConfig.CAFile != "" {
+		cacert, err := ioutil.ReadFile(k8sConfig.CAFile)
+		if err != nil {
+			return "", err
+		}
+		return string(cacert), nil
+	}
+	log.Warn("No CA certificate configured for the K8s API. Vault will use its system CAs")
+	return "", nil
+}
@@ -0,0 +1,39 @@
+package bootstrap
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Create a K8s ConfigMap holding data or replace the data of the existing one
+func applyK8sConfigMap(clientsetK8s *kubernetes.Clientset, configMapName string, data map[string]string) error {
+	configMapClient := clientsetK8s.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMapClient.Get(context.TODO(), configMapName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		configMap = &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: configMapName,
+			},
+			Data: data,
+		}
+		if _, err := configMapClient.Create(context.TODO(), configMap, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.Info("Created K8s ConfigMap ", configMapName)
+		return nil
+	}
+	configMap.Data = data
+	if _, err := configMapClient.Update(context.TODO(), configMap, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.Info("Updated K8s ConfigMap ", configMapName)
+	return nil
+}
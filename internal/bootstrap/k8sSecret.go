@@ -5,6 +5,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -43,3 +44,73 @@ func createK8sSecret(clientsetK8s *kubernetes.Clientset, secretName *string, vau
 	log.Info("Created K8s secret ", result.GetObjectMeta().GetName())
 	return nil
 }
+
+func getK8sSecret(clientsetK8s *kubernetes.Clientset, secretName string) (*apiv1.Secret, error) {
+	secretClient := clientsetK8s.CoreV1().Secrets(namespace)
+	return secretClient.Get(context.TODO(), secretName, metav1.GetOptions{})
+}
+
+// Create a K8s secret holding data or replace the data of the existing one
+func applyK8sSecret(clientsetK8s *kubernetes.Clientset, secretName string, data map[string]string) error {
+	return applyK8sSecretWithType(clientsetK8s, secretName, apiv1.SecretTypeOpaque, data)
+}
+
+func applyK8sSecretWithType(clientsetK8s *kubernetes.Clientset, secretName string, secretType apiv1.SecretType, data map[string]string) error {
+	return applyK8sSecretObject(clientsetK8s, secretName, secretType, data, nil)
+}
+
+// Create or update a K8s secret holding data, and set its annotations in the same request
+func applyK8sSecretWithAnnotations(clientsetK8s *kubernetes.Clientset, secretName string, data map[string]string, annotations map[string]string) error {
+	return applyK8sSecretObject(clientsetK8s, secretName, apiv1.SecretTypeOpaque, data, annotations)
+}
+
+func applyK8sSecretObject(clientsetK8s *kubernetes.Clientset, secretName string, secretType apiv1.SecretType, data map[string]string, annotations map[string]string) error {
+	secretClient := clientsetK8s.CoreV1().Secrets(namespace)
+	secret, err := secretClient.Get(context.TODO(), secretName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		secret = &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        secretName,
+				Annotations: annotations,
+			},
+			Type:       secretType,
+			StringData: data,
+		}
+		if _, err := secretClient.Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.Info("Created K8s secret ", secretName)
+		return nil
+	}
+	secret.Data = nil
+	secret.StringData = data
+	for key, value := range annotations {
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[key] = value
+	}
+	if _, err := secretClient.Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.Info("Updated K8s secret ", secretName)
+	return nil
+}
+
+// Set an annotation of an existing K8s secret
+func annotateK8sSecret(clientsetK8s *kubernetes.Clientset, secretName string, key string, value string) error {
+	secretClient := clientsetK8s.CoreV1().Secrets(namespace)
+	secret, err := secretClient.Get(context.TODO(), secretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[key] = value
+	_, err = secretClient.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,273 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultK8sSecretsEnginePath        = "kubernetes"
+	DefaultK8sSecretsEngineClusterRole = "vault-secrets-engine"
+)
+
+type k8sSecretsEngineConfig struct {
+	Path string `json:"path"`
+	// ClusterRole granting Vault's service account the permissions to manage service accounts,
+	// tokens and role bindings. Defaults to vault-secrets-engine
+	ClusterRole string `json:"clusterRole"`
+	// Namespaces in which the ClusterRole is bound to Vault's service account with RoleBindings
+	// If empty, it is bound cluster-wide with a ClusterRoleBinding
+	Namespaces []string                     `json:"namespaces"`
+	Roles      []k8sSecretsEngineRoleConfig `json:"roles"`
+}
+
+type k8sSecretsEngineRoleConfig struct {
+	Name                     string   `json:"name"`
+	AllowedNamespaces        []string `json:"allowedNamespaces"`
+	AllowedNamespaceSelector string   `json:"allowedNamespaceSelector"`
+	// Exactly one of serviceAccountName, kubernetesRoleName or generatedRoleRules must be set
+	ServiceAccountName string              `json:"serviceAccountName"`
+	KubernetesRoleName string              `json:"kubernetesRoleName"`
+	GeneratedRoleRules []rbacv1.PolicyRule `json:"generatedRoleRules"`
+	// Role (default) or ClusterRole
+	KubernetesRoleType    string            `json:"kubernetesRoleType"`
+	NameTemplate          string            `json:"nameTemplate"`
+	TokenDefaultTTL       string            `json:"tokenDefaultTTL"`
+	TokenMaxTTL           string            `json:"tokenMaxTTL"`
+	TokenDefaultAudiences []string          `json:"tokenDefaultAudiences"`
+	ExtraLabels           map[string]string `json:"extraLabels"`
+	ExtraAnnotations      map[string]string `json:"extraAnnotations"`
+}
+
+// Permissions needed by the kubernetes secrets engine, as documented by Vault
+var k8sSecretsEngineRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"namespaces"},
+		Verbs:     []string{"get"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"serviceaccounts", "serviceaccounts/token"},
+		Verbs:     []string{"create", "update", "delete"},
+	},
+	{
+		APIGroups: []string{"rbac.authorization.k8s.io"},
+		Resources: []string{"rolebindings", "clusterrolebindings"},
+		Verbs:     []string{"create", "update", "delete"},
+	},
+	{
+		APIGroups: []string{"rbac.authorization.k8s.io"},
+		Resources: []string{"roles", "clusterroles"},
+		Verbs:     []string{"bind", "escalate", "create", "update", "delete"},
+	},
+}
+
+func k8sSecretsEnginePath(config k8sSecretsEngineConfig) string {
+	if config.Path == "" {
+		return DefaultK8sSecretsEnginePath
+	}
+	return strings.Trim(config.Path, "/")
+}
+
+func configureK8sSecretsEngine(client *vault.Client, clientsetK8s *kubernetes.Clientset, config k8sSecretsEngineConfig) error {
+	path := k8sSecretsEnginePath(config)
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("K8s secrets engine: %s", err.Error())
+	}
+	if err := mountSecretsEngine(client, mounts, secretsEngineConfig{
+		Path: path,
+		Type: "kubernetes",
+	}); err != nil {
+		return fmt.Errorf("K8s secrets engine: %s", err.Error())
+	}
+
+	if err := applyK8sSecretsEngineRBAC(clientsetK8s, config); err != nil {
+		return fmt.Errorf("K8s secrets engine: %s", err.Error())
+	}
+
+	k8sApiUrl, cacert, vaultJwt, err := getK8sAPIConfig(clientsetK8s)
+	if err != nil {
+		return fmt.Errorf("K8s secrets engine: %s", err.Error())
+	}
+	_, err = client.Logical().Write(path+"/config", map[string]interface{}{
+		"kubernetes_host":     k8sApiUrl,
+		"kubernetes_ca_cert":  cacert,
+		"service_account_jwt": vaultJwt,
+	})
+	if err != nil {
+		return fmt.Errorf("K8s secrets engine: %s", err.Error())
+	}
+
+	for _, role := range config.Roles {
+		if err := configureK8sSecretsEngineRole(client, path, role); err != nil {
+			return fmt.Errorf("K8s secrets engine: role %s: %s", role.Name, err.Error())
+		}
+	}
+	log.Info("K8s secrets engine: Successfully configured")
+	return nil
+}
+
+// applyK8sSecretsEngineRBAC creates or updates the ClusterRole and binds it to Vault's service account
+func applyK8sSecretsEngineRBAC(clientsetK8s *kubernetes.Clientset, config k8sSecretsEngineConfig) error {
+	clusterRoleName := config.ClusterRole
+	if clusterRoleName == "" {
+		clusterRoleName = DefaultK8sSecretsEngineClusterRole
+	}
+	rbacClient := clientsetK8s.RbacV1()
+
+	clusterRole, err := rbacClient.ClusterRoles().Get(context.TODO(), clusterRoleName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		clusterRole = &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: clusterRoleName},
+			Rules:      k8sSecretsEngineRules,
+		}
+		if _, err := rbacClient.ClusterRoles().Create(context.TODO(), clusterRole, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.Info("K8s secrets engine: Created ClusterRole ", clusterRoleName)
+	} else {
+		clusterRole.Rules = k8sSecretsEngineRules
+		if _, err := rbacClient.ClusterRoles().Update(context.TODO(), clusterRole, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		log.Debug("K8s secrets engine: Updated ClusterRole ", clusterRoleName)
+	}
+
+	roleRef := rbacv1.RoleRef{
+		APIGroup: "rbac.authorization.k8s.io",
+		Kind:     "ClusterRole",
+		Name:     clusterRoleName,
+	}
+	subjects := []rbacv1.Subject{{
+		Kind:      "ServiceAccount",
+		Name:      vaultServiceAccount,
+		Namespace: namespace,
+	}}
+
+	if len(config.Namespaces) == 0 {
+		return applyK8sClusterRoleBinding(clientsetK8s, &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: clusterRoleName},
+			RoleRef:    roleRef,
+			Subjects:   subjects,
+		})
+	}
+	for _, ns := range config.Namespaces {
+		err := applyK8sRoleBinding(clientsetK8s, &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: clusterRoleName, Namespace: ns},
+			RoleRef:    roleRef,
+			Subjects:   subjects,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyK8sClusterRoleBinding(clientsetK8s *kubernetes.Clientset, binding *rbacv1.ClusterRoleBinding) error {
+	bindingClient := clientsetK8s.RbacV1().ClusterRoleBindings()
+	current, err := bindingClient.Get(context.TODO(), binding.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if _, err := bindingClient.Create(context.TODO(), binding, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.Info("K8s secrets engine: Created ClusterRoleBinding ", binding.Name)
+		return nil
+	}
+	// The role of a binding cannot be changed
+	if current.RoleRef != binding.RoleRef {
+		return fmt.Errorf("ClusterRoleBinding %s already exists and references %s %s", binding.Name, current.RoleRef.Kind, current.RoleRef.Name)
+	}
+	current.Subjects = binding.Subjects
+	_, err = bindingClient.Update(context.TODO(), current, metav1.UpdateOptions{})
+	return err
+}
+
+func applyK8sRoleBinding(clientsetK8s *kubernetes.Clientset, binding *rbacv1.RoleBinding) error {
+	bindingClient := clientsetK8s.RbacV1().RoleBindings(binding.Namespace)
+	current, err := bindingClient.Get(context.TODO(), binding.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if _, err := bindingClient.Create(context.TODO(), binding, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.Infof("K8s secrets engine: Created RoleBinding %s in namespace %s", binding.Name, binding.Namespace)
+		return nil
+	}
+	// The role of a binding cannot be changed
+	if current.RoleRef != binding.RoleRef {
+		return fmt.Errorf("RoleBinding %s already exists in namespace %s and references %s %s", binding.Name, binding.Namespace, current.RoleRef.Kind, current.RoleRef.Name)
+	}
+	current.Subjects = binding.Subjects
+	_, err = bindingClient.Update(context.TODO(), current, metav1.UpdateOptions{})
+	return err
+}
+
+func configureK8sSecretsEngineRole(client *vault.Client, path string, role k8sSecretsEngineRoleConfig) error {
+	if role.Name == "" {
+		return fmt.Errorf("name is mandatory")
+	}
+	if len(role.AllowedNamespaces) == 0 && role.AllowedNamespaceSelector == "" {
+		return fmt.Errorf("one of allowedNamespaces or allowedNamespaceSelector is mandatory")
+	}
+	set := 0
+	for _, isSet := range []bool{role.ServiceAccountName != "", role.KubernetesRoleName != "", len(role.GeneratedRoleRules) > 0} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of serviceAccountName, kubernetesRoleName or generatedRoleRules must be set")
+	}
+
+	data := map[string]interface{}{
+		"allowed_kubernetes_namespaces":         role.AllowedNamespaces,
+		"allowed_kubernetes_namespace_selector": role.AllowedNamespaceSelector,
+		"service_account_name":                  role.ServiceAccountName,
+		"kubernetes_role_name":                  role.KubernetesRoleName,
+		"name_template":                         role.NameTemplate,
+		"token_default_ttl":                     role.TokenDefaultTTL,
+		"token_max_ttl":                         role.TokenMaxTTL,
+		"token_default_audiences":               role.TokenDefaultAudiences,
+	}
+	if role.ExtraLabels != nil {
+		data["extra_labels"] = role.ExtraLabels
+	}
+	if role.ExtraAnnotations != nil {
+		data["extra_annotations"] = role.ExtraAnnotations
+	}
+	if role.KubernetesRoleType != "" {
+		data["kubernetes_role_type"] = role.KubernetesRoleType
+	}
+	if len(role.GeneratedRoleRules) > 0 {
+		rules, err := json.Marshal(map[string]interface{}{"rules": role.GeneratedRoleRules})
+		if err != nil {
+			return err
+		}
+		data["generated_role_rules"] = string(rules)
+	}
+	if _, err := client.Logical().Write(fmt.Sprintf("%s/roles/%s", path, role.Name), data); err != nil {
+		return err
+	}
+	log.Infof("K8s secrets engine: Role %s configured", role.Name)
+	return nil
+}
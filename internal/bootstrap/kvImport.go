@@ -0,0 +1,175 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const DefaultKVImportMount = "secret"
+
+const (
+	kvImportImported    = "imported"
+	kvImportSkipped     = "skipped"
+	kvImportConflicting = "conflicting"
+)
+
+type kvImportConfig struct {
+	// KV version 2 mount where the secrets are imported
+	Mount string `json:"mount"`
+	// Replace the KV entries whose data differ from the K8s secret
+	Overwrite bool              `json:"overwrite"`
+	Mappings  []kvImportMapping `json:"mappings"`
+}
+
+type kvImportMapping struct {
+	// Defaults to the namespace of vault-bootstrap
+	Namespace string `json:"namespace"`
+	// Either a secret name, imported at path, or a label selector,
+	// in which case each selected secret is imported at <path>/<secret name>
+	Secret   string `json:"secret"`
+	Selector string `json:"selector"`
+	Path     string `json:"path"`
+}
+
+// Outcome of the import of a single KV path. Never holds secret values
+type kvImportResult struct {
+	source string
+	path   string
+	status string
+}
+
+func kvImportMount(config kvImportConfig) string {
+	if config.Mount == "" {
+		return DefaultKVImportMount
+	}
+	return strings.Trim(config.Mount, "/")
+}
+
+func configureKVImport(client *vault.Client, clientsetK8s *kubernetes.Clientset, config kvImportConfig) error {
+	mount := kvImportMount(config)
+
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("KV import: %s", err.Error())
+	}
+	if m, ok := mounts[mount+"/"]; !ok || m.Type != "kv" || m.Options["version"] != "2" {
+		return fmt.Errorf("KV import: %s is not a kv version 2 engine", mount)
+	}
+
+	var results []kvImportResult
+	for _, mapping := range config.Mappings {
+		secrets, err := getKVImportSecrets(clientsetK8s, mapping)
+		if err != nil {
+			return fmt.Errorf("KV import: %s", err.Error())
+		}
+		for _, secret := range secrets {
+			path := strings.Trim(mapping.Path, "/")
+			if mapping.Selector != "" {
+				path = path + "/" + secret.Name
+			}
+			status, err := importK8sSecret(client, mount, path, secret, config.Overwrite)
+			if err != nil {
+				return fmt.Errorf("KV import: %s/%s: %s", secret.Namespace, secret.Name, err.Error())
+			}
+			results = append(results, kvImportResult{
+				source: secret.Namespace + "/" + secret.Name,
+				path:   mount + "/" + path,
+				status: status,
+			})
+		}
+	}
+	logKVImportReport(results)
+	return nil
+}
+
+func getKVImportSecrets(clientsetK8s *kubernetes.Clientset, mapping kvImportMapping) ([]apiv1.Secret, error) {
+	ns := mapping.Namespace
+	if ns == "" {
+		ns = namespace
+	}
+	if mapping.Path == "" {
+		return nil, fmt.Errorf("path is mandatory")
+	}
+	if (mapping.Secret == "") == (mapping.Selector == "") {
+		return nil, fmt.Errorf("exactly one of secret or selector must be set for path %s", mapping.Path)
+	}
+	secretClient := clientsetK8s.CoreV1().Secrets(ns)
+	if mapping.Secret != "" {
+		secret, err := secretClient.Get(context.TODO(), mapping.Secret, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []apiv1.Secret{*secret}, nil
+	}
+	secrets, err := secretClient.List(context.TODO(), metav1.ListOptions{LabelSelector: mapping.Selector})
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets.Items) == 0 {
+		log.Warnf("KV import: No secret matching %s in namespace %s", mapping.Selector, ns)
+	}
+	return secrets.Items, nil
+}
+
+// importK8sSecret writes the secret data to the KV path using check-and-set
+// Existing entries are replaced only if overwrite is set and their data differ
+func importK8sSecret(client *vault.Client, mount string, path string, secret apiv1.Secret, overwrite bool) (string, error) {
+	data := map[string]interface{}{}
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	dataPath := fmt.Sprintf("%s/data/%s", mount, path)
+
+	current, err := client.Logical().Read(dataPath)
+	if err != nil {
+		return "", err
+	}
+	// Entries which were deleted (but not destroyed) are returned without data
+	cas := 0
+	if current != nil && current.Data["data"] != nil {
+		if reflect.DeepEqual(current.Data["data"], data) {
+			return kvImportSkipped, nil
+		}
+		if !overwrite {
+			return kvImportConflicting, nil
+		}
+	}
+	if current != nil {
+		if metadata, ok := current.Data["metadata"].(map[string]interface{}); ok {
+			cas, _ = strconv.Atoi(fmt.Sprint(metadata["version"]))
+		}
+	}
+
+	_, err = client.Logical().Write(dataPath, map[string]interface{}{
+		"options": map[string]interface{}{
+			"cas": cas,
+		},
+		"data": data,
+	})
+	if err != nil {
+		// Entry was changed since it was read
+		if strings.Contains(err.Error(), "check-and-set") {
+			return kvImportConflicting, nil
+		}
+		return "", err
+	}
+	return kvImportImported, nil
+}
+
+func logKVImportReport(results []kvImportResult) {
+	count := map[string]int{}
+	for _, result := range results {
+		count[result.status]++
+		log.Infof("KV import: %-11s %s -> %s", result.status, result.source, result.path)
+	}
+	log.Infof("KV import: %d imported, %d skipped, %d conflicting", count[kvImportImported], count[kvImportSkipped], count[kvImportConflicting])
+}
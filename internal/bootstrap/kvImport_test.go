@@ -0,0 +1,87 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// fakeMountsVault serves the sys/mounts endpoints used by the secrets engines and KV import steps
+type fakeMountsVault struct {
+	mu        sync.Mutex
+	mounts    map[string]*vault.MountOutput
+	unmounted []string
+	tuned     map[string]vault.MountConfigInput
+}
+
+func newFakeMountsVault(t *testing.T, mounts map[string]*vault.MountOutput) (*fakeMountsVault, *vault.Client) {
+	fake := &fakeMountsVault{mounts: mounts, tuned: map[string]vault.MountConfigInput{}}
+	return fake, newFakeVaultClient(t, fake)
+}
+
+func (f *fakeMountsVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/sys/mounts/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/sys/mounts":
+		writeFakeData(w, f.mounts)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/tune"):
+		var tune vault.MountConfigInput
+		if err := json.NewDecoder(r.Body).Decode(&tune); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.tuned[strings.TrimSuffix(path, "/tune")] = tune
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && path != r.URL.Path:
+		var input vault.MountInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mounts[path+"/"] = &vault.MountOutput{Type: input.Type, Description: input.Description, Options: input.Options}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && path != r.URL.Path:
+		delete(f.mounts, path+"/")
+		f.unmounted = append(f.unmounted, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestKVImportMountSurvivesPrune(t *testing.T) {
+	for name, mount := range map[string]string{"default mount": "", "configured mount": "/apps/"} {
+		t.Run(name, func(t *testing.T) {
+			path := kvImportMount(kvImportConfig{Mount: mount})
+			fake, client := newFakeMountsVault(t, map[string]*vault.MountOutput{
+				"sys/":       {Type: "system"},
+				"cubbyhole/": {Type: "cubbyhole"},
+				path + "/":   {Type: "kv", Options: map[string]string{"version": "2"}},
+				"scratch/":   {Type: "kv", Options: map[string]string{"version": "1"}},
+			})
+			config := &bootstrapConfig{
+				SecretsEngines:      []secretsEngineConfig{{Path: "team", Type: "kv-v2"}},
+				PruneSecretsEngines: true,
+				KVImport:            kvImportConfig{Mount: mount},
+			}
+
+			if err := configureSecretsEngines(client, config); err != nil {
+				t.Fatalf("configureSecretsEngines: %s", err)
+			}
+			if len(fake.unmounted) != 1 || fake.unmounted[0] != "scratch" {
+				t.Fatalf("expected only scratch to be unmounted, got %v", fake.unmounted)
+			}
+			// The KV import step runs after the prune and needs its mount
+			if err := configureKVImport(client, nil, config.KVImport); err != nil {
+				t.Fatalf("configureKVImport after prune: %s", err)
+			}
+		})
+	}
+}
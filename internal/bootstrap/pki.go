@@ -0,0 +1,279 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultPKIRootPath        = "pki"
+	DefaultPKIRootTTL         = "87600h"
+	DefaultPKIIntermediateTTL = "43800h"
+	DefaultPKIKeyType         = "rsa"
+	DefaultPKIConfigMap       = "vault-pki-ca"
+)
+
+type pkiConfig struct {
+	Root pkiCAConfig `json:"root"`
+	// Optional. If set, the roles are created on the intermediate CA
+	Intermediate pkiCAConfig `json:"intermediate"`
+	// Vault URL used by clients to build the issuing certificate and CRL URLs
+	// e.g. https://vault.example.com:8200
+	BaseURL string          `json:"baseURL"`
+	Roles   []pkiRoleConfig `json:"roles"`
+	// ConfigMap where the root and intermediate certificates are published
+	ConfigMap string `json:"configMap"`
+}
+
+type pkiCAConfig struct {
+	Path       string `json:"path"`
+	CommonName string `json:"commonName"`
+	TTL        string `json:"ttl"`
+	KeyType    string `json:"keyType"`
+	KeyBits    int    `json:"keyBits"`
+	// Defaults to ttl
+	MaxLeaseTTL string `json:"maxLeaseTTL"`
+}
+
+type pkiRoleConfig struct {
+	Name             string   `json:"name"`
+	AllowedDomains   []string `json:"allowedDomains"`
+	AllowSubdomains  bool     `json:"allowSubdomains"`
+	AllowBareDomains bool     `json:"allowBareDomains"`
+	AllowGlobDomains bool     `json:"allowGlobDomains"`
+	AllowAnyName     bool     `json:"allowAnyName"`
+	TTL              string   `json:"ttl"`
+	MaxTTL           string   `json:"maxTTL"`
+	KeyType          string   `json:"keyType"`
+	KeyBits          int      `json:"keyBits"`
+}
+
+func configurePKI(client *vault.Client, clientsetK8s *kubernetes.Clientset, config pkiConfig) error {
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("PKI: %s", err.Error())
+	}
+
+	root := config.Root
+	root.Path = pkiRootPath(config)
+	if root.TTL == "" {
+		root.TTL = DefaultPKIRootTTL
+	}
+	rootCert, err := configurePKIRoot(client, mounts, root)
+	if err != nil {
+		return fmt.Errorf("PKI: root CA: %s", err.Error())
+	}
+	if err := configurePKIURLs(client, root.Path, config.BaseURL); err != nil {
+		return fmt.Errorf("PKI: root CA: %s", err.Error())
+	}
+
+	// Roles are created on the CA issuing the certificates
+	issuingPath := root.Path
+	publishedCerts := map[string]string{
+		"root.crt":      rootCert,
+		"ca-bundle.crt": rootCert,
+	}
+
+	intermediate := config.Intermediate
+	intermediate.Path = strings.Trim(intermediate.Path, "/")
+	if intermediate.Path != "" {
+		if intermediate.TTL == "" {
+			intermediate.TTL = DefaultPKIIntermediateTTL
+		}
+		intermediateCert, err := configurePKIIntermediate(client, mounts, root.Path, intermediate)
+		if err != nil {
+			return fmt.Errorf("PKI: intermediate CA: %s", err.Error())
+		}
+		if err := configurePKIURLs(client, intermediate.Path, config.BaseURL); err != nil {
+			return fmt.Errorf("PKI: intermediate CA: %s", err.Error())
+		}
+		issuingPath = intermediate.Path
+		publishedCerts["intermediate.crt"] = intermediateCert
+		publishedCerts["ca-bundle.crt"] = intermediateCert + "\n" + rootCert
+	}
+
+	for _, role := range config.Roles {
+		if err := configurePKIRole(client, issuingPath, role); err != nil {
+			return fmt.Errorf("PKI: role %s: %s", role.Name, err.Error())
+		}
+	}
+
+	configMapName := config.ConfigMap
+	if configMapName == "" {
+		configMapName = DefaultPKIConfigMap
+	}
+	if err := applyK8sConfigMap(clientsetK8s, configMapName, publishedCerts); err != nil {
+		return fmt.Errorf("PKI: %s", err.Error())
+	}
+	log.Info("PKI: Successfully configured")
+	return nil
+}
+
+func pkiRootPath(config pkiConfig) string {
+	if config.Root.Path == "" {
+		return DefaultPKIRootPath
+	}
+	return strings.Trim(config.Root.Path, "/")
+}
+
+// Mount the root PKI engine and generate the root CA, unless it already exists
+// Returns the root CA certificate
+func configurePKIRoot(client *vault.Client, mounts map[string]*vault.MountOutput, root pkiCAConfig) (string, error) {
+	if err := mountPKI(client, mounts, root); err != nil {
+		return "", err
+	}
+	cert, err := getPKICACert(client, root.Path)
+	if err != nil {
+		return "", err
+	}
+	if cert != "" {
+		log.Infof("PKI: Root CA already generated in %s", root.Path)
+		return cert, nil
+	}
+
+	if root.CommonName == "" {
+		return "", fmt.Errorf("commonName is mandatory")
+	}
+	resp, err := client.Logical().Write(root.Path+"/root/generate/internal", pkiKeyData(root))
+	if err != nil {
+		return "", err
+	}
+	if resp == nil || resp.Data["certificate"] == nil {
+		return "", fmt.Errorf("cannot generate root CA")
+	}
+	log.Infof("PKI: Root CA generated in %s", root.Path)
+	return strings.TrimSpace(resp.Data["certificate"].(string)), nil
+}
+
+// Mount the intermediate PKI engine, generate a CSR and sign it with the root CA,
+// unless the intermediate CA already exists. Returns the intermediate CA certificate
+func configurePKIIntermediate(client *vault.Client, mounts map[string]*vault.MountOutput, rootPath string, intermediate pkiCAConfig) (string, error) {
+	if err := mountPKI(client, mounts, intermediate); err != nil {
+		return "", err
+	}
+	cert, err := getPKICACert(client, intermediate.Path)
+	if err != nil {
+		return "", err
+	}
+	if cert != "" {
+		log.Infof("PKI: Intermediate CA already generated in %s", intermediate.Path)
+		return cert, nil
+	}
+
+	if intermediate.CommonName == "" {
+		return "", fmt.Errorf("commonName is mandatory")
+	}
+	csrResp, err := client.Logical().Write(intermediate.Path+"/intermediate/generate/internal", pkiKeyData(intermediate))
+	if err != nil {
+		return "", err
+	}
+	if csrResp == nil || csrResp.Data["csr"] == nil {
+		return "", fmt.Errorf("cannot generate intermediate CSR")
+	}
+
+	signResp, err := client.Logical().Write(rootPath+"/root/sign-intermediate", map[string]interface{}{
+		"csr":         csrResp.Data["csr"],
+		"common_name": intermediate.CommonName,
+		"ttl":         intermediate.TTL,
+		"format":      "pem",
+	})
+	if err != nil {
+		return "", err
+	}
+	if signResp == nil || signResp.Data["certificate"] == nil {
+		return "", fmt.Errorf("cannot sign intermediate CSR")
+	}
+	cert = strings.TrimSpace(signResp.Data["certificate"].(string))
+
+	if _, err := client.Logical().Write(intermediate.Path+"/intermediate/set-signed", map[string]interface{}{
+		"certificate": cert,
+	}); err != nil {
+		return "", err
+	}
+	log.Infof("PKI: Intermediate CA generated in %s and signed by %s", intermediate.Path, rootPath)
+	return cert, nil
+}
+
+func mountPKI(client *vault.Client, mounts map[string]*vault.MountOutput, ca pkiCAConfig) error {
+	maxLeaseTTL := ca.MaxLeaseTTL
+	if maxLeaseTTL == "" {
+		maxLeaseTTL = ca.TTL
+	}
+	return mountSecretsEngine(client, mounts, secretsEngineConfig{
+		Path:        ca.Path,
+		Type:        "pki",
+		MaxLeaseTTL: maxLeaseTTL,
+	})
+}
+
+// getPKICACert returns the CA certificate of the PKI engine at path or an empty string if not generated yet
+func getPKICACert(client *vault.Client, path string) (string, error) {
+	resp, err := client.Logical().Read(path + "/cert/ca")
+	if err != nil {
+		return "", err
+	}
+	if resp == nil || resp.Data["certificate"] == nil {
+		return "", nil
+	}
+	return strings.TrimSpace(resp.Data["certificate"].(string)), nil
+}
+
+func pkiKeyData(ca pkiCAConfig) map[string]interface{} {
+	keyType := ca.KeyType
+	if keyType == "" {
+		keyType = DefaultPKIKeyType
+	}
+	data := map[string]interface{}{
+		"common_name": ca.CommonName,
+		"ttl":         ca.TTL,
+		"key_type":    keyType,
+	}
+	if ca.KeyBits != 0 {
+		data["key_bits"] = ca.KeyBits
+	}
+	return data
+}
+
+func configurePKIURLs(client *vault.Client, path string, baseURL string) error {
+	if baseURL == "" {
+		return nil
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	_, err := client.Logical().Write(path+"/config/urls", map[string]interface{}{
+		"issuing_certificates":    []string{fmt.Sprintf("%s/v1/%s/ca", baseURL, path)},
+		"crl_distribution_points": []string{fmt.Sprintf("%s/v1/%s/crl", baseURL, path)},
+	})
+	return err
+}
+
+func configurePKIRole(client *vault.Client, path string, role pkiRoleConfig) error {
+	if role.Name == "" {
+		return fmt.Errorf("name is mandatory")
+	}
+	keyType := role.KeyType
+	if keyType == "" {
+		keyType = DefaultPKIKeyType
+	}
+	data := map[string]interface{}{
+		"allowed_domains":    role.AllowedDomains,
+		"allow_subdomains":   role.AllowSubdomains,
+		"allow_bare_domains": role.AllowBareDomains,
+		"allow_glob_domains": role.AllowGlobDomains,
+		"allow_any_name":     role.AllowAnyName,
+		"ttl":                role.TTL,
+		"max_ttl":            role.MaxTTL,
+		"key_type":           keyType,
+	}
+	if role.KeyBits != 0 {
+		data["key_bits"] = role.KeyBits
+	}
+	if _, err := client.Logical().Write(fmt.Sprintf("%s/roles/%s", path, role.Name), data); err != nil {
+		return err
+	}
+	log.Infof("PKI: Role %s configured in %s", role.Name, path)
+	return nil
+}
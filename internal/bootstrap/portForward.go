@@ -0,0 +1,162 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// DefaultVaultPort is forwarded when a cluster member URL has no port
+const DefaultVaultPort = "8200"
+
+type portForward struct {
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+var (
+	portForwards   []portForward
+	portForwardsMu sync.Mutex
+	closeOnce      sync.Once
+	signalOnce     sync.Once
+	exitHooks      []func()
+)
+
+// forwardVaultAddress opens a port-forward through the K8s API to the Vault port of the pod
+// serving member, and returns the local address to use instead of member
+func forwardVaultAddress(member string) (string, error) {
+	memberURL, err := url.Parse(member)
+	if err != nil {
+		return "", err
+	}
+	podName := strings.Split(memberURL.Hostname(), ".")[0]
+	port := memberURL.Port()
+	if port == "" {
+		port = DefaultVaultPort
+	}
+
+	k8sConfig, err := newK8sConfig()
+	if err != nil {
+		return "", err
+	}
+	clientsetK8s, err := newK8sClientset()
+	if err != nil {
+		return "", err
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(k8sConfig)
+	if err != nil {
+		return "", err
+	}
+	req := clientsetK8s.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(podName).SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	pf := portForward{stopCh: make(chan struct{}), doneCh: make(chan struct{})}
+	readyCh := make(chan struct{})
+	forwarder, err := portforward.New(dialer, []string{"0:" + port}, pf.stopCh, readyCh, ioutil.Discard, log.StandardLogger().WriterLevel(log.WarnLevel))
+	if err != nil {
+		return "", err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- forwarder.ForwardPorts()
+		close(pf.doneCh)
+	}()
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		return "", fmt.Errorf("Port-forward to pod %s: %s", podName, err.Error())
+	}
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		close(pf.stopCh)
+		return "", err
+	}
+
+	portForwardsMu.Lock()
+	if len(portForwards) == 0 {
+		closeOnSignal()
+	}
+	portForwards = append(portForwards, pf)
+	portForwardsMu.Unlock()
+
+	address := fmt.Sprintf("%s://127.0.0.1:%d", memberURL.Scheme, ports[0].Local)
+	log.Infof("%s: Forwarding %s to port %s of pod %s", podName, address, port, podName)
+	return address, nil
+}
+
+// configureForwardedClient points the Vault client configuration of member at a port-forward
+// to its pod. The TLS server name stays the one of member, so that its certificate is verified
+func configureForwardedClient(config *vault.Config, member string) error {
+	address, err := forwardVaultAddress(member)
+	if err != nil {
+		return err
+	}
+	memberURL, _ := url.Parse(member)
+	config.Address = address
+	if transport, ok := config.HttpClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.ServerName = memberURL.Hostname()
+	}
+	return nil
+}
+
+// closeOnSignal closes the port-forwards when the process is interrupted
+func closeOnSignal() {
+	signalOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			log.Warnf("Received %s. Exiting", sig)
+			exit(1)
+		}()
+	})
+}
+
+// onExit registers f to run when the process exits with exit, including on interruption
+func onExit(f func()) {
+	portForwardsMu.Lock()
+	exitHooks = append(exitHooks, f)
+	portForwardsMu.Unlock()
+	closeOnSignal()
+}
+
+// Close stops the port-forwards to the Vault pods and waits until they are closed
+func Close() {
+	closeOnce.Do(func() {
+		portForwardsMu.Lock()
+		defer portForwardsMu.Unlock()
+		for _, pf := range portForwards {
+			close(pf.stopCh)
+		}
+		for _, pf := range portForwards {
+			<-pf.doneCh
+		}
+		if len(portForwards) > 0 {
+			log.Debugf("%d port-forwards closed", len(portForwards))
+		}
+	})
+}
+
+// exit runs the exit hooks and closes the port-forwards before exiting with code
+func exit(code int) {
+	portForwardsMu.Lock()
+	hooks := exitHooks
+	portForwardsMu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+	Close()
+	os.Exit(code)
+}
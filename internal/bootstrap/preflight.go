@@ -1,8 +1,6 @@
 package bootstrap
 
 import (
-	"crypto/tls"
-	"net/http"
 	"strconv"
 	"time"
 
@@ -24,14 +22,16 @@ func preflight(vaultPods []vaultPod) {
 }
 
 func checkVaultStatus(pod vaultPod, c chan string) {
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	for {
-		resp, err := http.Get(pod.fqdn + "/v1/sys/health")
+		// The address of the client differs from the member URL when port-forwarded
+		resp, err := pod.httpClient.Get(pod.client.Address() + "/v1/sys/health")
 		if err != nil {
 			log.Debugf("%s: %s", pod.name, err.Error())
 			time.Sleep(3 * time.Second)
 			continue
-		} else if !find(vaultReadyStatusCodes, resp.StatusCode) {
+		}
+		resp.Body.Close()
+		if !find(vaultReadyStatusCodes, resp.StatusCode) {
 			log.Debugf("%s: HTTP Status %s", pod.name, strconv.Itoa(resp.StatusCode))
 			time.Sleep(3 * time.Second)
 			continue
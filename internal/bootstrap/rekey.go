@@ -0,0 +1,314 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Suffixes of the secrets holding the previous unseal keys and the PGP encrypted new ones
+	RekeyBackupSuffix = "-backup"
+	RekeyPGPSuffix    = "-pgp"
+)
+
+// rekeyRequest holds the options of the rekey command
+type rekeyRequest struct {
+	shares    int
+	threshold int
+	pgpKeys   []string
+}
+
+// Rekey generates new unseal keys with the given shares and threshold (0 keeps the current ones)
+// using the stored unseal keys. The previous keys are kept in a backup secret until the new ones
+// are verified. With PGP keys, the encrypted new keys are stored apart and the verification is
+// left to the key holders, after which confirm removes the previous keys from the key store
+func Rekey(shares int, threshold int, pgpKeys string, confirm bool) int {
+	clientsetK8s, err := newK8sClientset()
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	clientConfig, err := newVaultLBConfig()
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	client, err := vault.NewClient(clientConfig)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+
+	if confirm {
+		err = confirmRekey(client, clientsetK8s)
+	} else {
+		request := rekeyRequest{shares: shares, threshold: threshold}
+		if pgpKeys != "" {
+			request.pgpKeys = strings.Split(pgpKeys, ",")
+		}
+		err = rekey(client, clientsetK8s, request)
+	}
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	return ExitOK
+}
+
+func rekey(client *vault.Client, clientsetK8s *kubernetes.Clientset, request rekeyRequest) error {
+	sealStatus, err := client.Sys().SealStatus()
+	if err != nil {
+		return fmt.Errorf("Rekey: %s", err.Error())
+	}
+	if !sealStatus.Initialized || sealStatus.Sealed {
+		return fmt.Errorf("Rekey: Vault must be initialized and unsealed")
+	}
+	if request.shares == 0 {
+		request.shares = sealStatus.N
+	}
+	if request.threshold == 0 {
+		request.threshold = sealStatus.T
+	}
+	if request.threshold < 1 || request.threshold > request.shares {
+		return fmt.Errorf("Rekey: Key threshold (%d) must be between 1 and key shares (%d)", request.threshold, request.shares)
+	}
+	if len(request.pgpKeys) > 0 && len(request.pgpKeys) != request.shares {
+		return fmt.Errorf("Rekey: %d PGP keys given for %d key shares", len(request.pgpKeys), request.shares)
+	}
+
+	status, err := client.Sys().RekeyStatus()
+	if err != nil {
+		return fmt.Errorf("Rekey: %s", err.Error())
+	}
+	if status.Started {
+		return fmt.Errorf("Rekey: A rekey is already in progress (nonce %s). Cancel it with 'vault operator rekey -cancel'", status.Nonce)
+	}
+
+	oldKeysData, err := getValuesFromK8sSecret(clientsetK8s, &vaultSecretUnseal)
+	if err != nil {
+		return fmt.Errorf("Rekey: Cannot load Unseal Keys - %s", err.Error())
+	}
+	oldKeys := strings.Split(*oldKeysData, ";")
+
+	// Old keys stay valid until the new ones are verified, so they are kept aside until then
+	backupSecret := vaultSecretUnseal + RekeyBackupSuffix
+	if err := applyK8sSecret(clientsetK8s, backupSecret, map[string]string{"vaultData": *oldKeysData}); err != nil {
+		return fmt.Errorf("Rekey: Cannot back up the Unseal Keys - %s", err.Error())
+	}
+	// Until the new keys are stored, the previous ones are still in vaultSecretUnseal,
+	// so the backup is removed when the rekey doesn't go through
+	cancel := func(err error) error {
+		return deleteRekeyBackup(clientsetK8s, backupSecret, cancelRekey(client, err))
+	}
+
+	status, err = client.Sys().RekeyInit(&vault.RekeyInitRequest{
+		SecretShares:        request.shares,
+		SecretThreshold:     request.threshold,
+		PGPKeys:             request.pgpKeys,
+		RequireVerification: true,
+	})
+	if err != nil {
+		return deleteRekeyBackup(clientsetK8s, backupSecret, fmt.Errorf("Rekey: %s", err.Error()))
+	}
+	nonce := status.Nonce
+	log.Infof("Rekey: Started with nonce %s, %d key shares and a threshold of %d", nonce, request.shares, request.threshold)
+
+	var update *vault.RekeyUpdateResponse
+	for _, key := range oldKeys {
+		if update, err = client.Sys().RekeyUpdate(key, nonce); err != nil {
+			return cancel(fmt.Errorf("Rekey: %s", err.Error()))
+		}
+		if update.Nonce != nonce {
+			return cancel(fmt.Errorf("Rekey: Nonce changed from %s to %s. Another rekey may be in progress", nonce, update.Nonce))
+		}
+		if update.Complete {
+			break
+		}
+	}
+	if update == nil || !update.Complete {
+		return cancel(fmt.Errorf("Rekey: Not enough Unseal Keys stored to reach the threshold of %d", status.Required))
+	}
+
+	if len(request.pgpKeys) > 0 {
+		pgpSecret := vaultSecretUnseal + RekeyPGPSuffix
+		if err := applyK8sSecret(clientsetK8s, pgpSecret, map[string]string{
+			"vaultData":         strings.Join(update.KeysB64, ";"),
+			"pgpFingerprints":   strings.Join(update.PGPFingerprints, ";"),
+			"verificationNonce": update.VerificationNonce,
+		}); err != nil {
+			return cancel(fmt.Errorf("Rekey: Cannot store the encrypted Unseal Keys - %s", err.Error()))
+		}
+		log.Infof("Rekey: PGP encrypted Unseal Keys stored in secret %s", pgpSecret)
+		log.Warnf("Rekey: The key holders must verify their keys with 'vault operator rekey -verify -nonce %s'. "+
+			"The previous keys stay valid until then. Run 'vault-bootstrap rekey --confirm' afterwards", update.VerificationNonce)
+		log.Warnf("Rekey: Once verified, %s no longer holds valid Unseal Keys. The previous ones are kept in secret %s until confirmed", vaultSecretUnseal, backupSecret)
+		return nil
+	}
+
+	// Replace the stored keys in a single update, then verify the keys read back from the secret
+	newKeysData := strings.Join(update.Keys, ";")
+	if err := applyK8sSecret(clientsetK8s, vaultSecretUnseal, map[string]string{"vaultData": newKeysData}); err != nil {
+		return cancel(fmt.Errorf("Rekey: Cannot store the new Unseal Keys - %s", err.Error()))
+	}
+	storedKeysData, err := getValuesFromK8sSecret(clientsetK8s, &vaultSecretUnseal)
+	if err == nil && *storedKeysData != newKeysData {
+		err = fmt.Errorf("stored keys differ from the generated ones")
+	}
+	if err == nil {
+		err = verifyRekey(client, strings.Split(*storedKeysData, ";"), update.VerificationNonce)
+	}
+	if err != nil {
+		restoreErr := applyK8sSecret(clientsetK8s, vaultSecretUnseal, map[string]string{"vaultData": *oldKeysData})
+		err = cancelRekey(client, fmt.Errorf("Rekey: Verification failed - %s", err.Error()))
+		if restoreErr != nil {
+			// The backup is then the only copy of the previous keys
+			return fmt.Errorf("%s. Cannot restore the previous Unseal Keys, they are kept in secret %s - %s", err.Error(), backupSecret, restoreErr.Error())
+		}
+		return deleteRekeyBackup(clientsetK8s, backupSecret, fmt.Errorf("%s. Previous Unseal Keys restored", err.Error()))
+	}
+	log.Info("Rekey: New Unseal Keys verified and stored in secret ", vaultSecretUnseal)
+
+	if err := deleteK8sSecret(clientsetK8s, backupSecret); err != nil {
+		log.Warnf("Rekey: Cannot delete the backup of the previous Unseal Keys %s - %s", backupSecret, err.Error())
+	}
+	return nil
+}
+
+// verifyRekey submits the new keys to the rekey verification, which activates them once complete
+func verifyRekey(client *vault.Client, keys []string, nonce string) error {
+	for _, key := range keys {
+		update, err := client.Sys().RekeyVerificationUpdate(key, nonce)
+		if err != nil {
+			return err
+		}
+		if update.Nonce != nonce {
+			return fmt.Errorf("verification nonce changed from %s to %s", nonce, update.Nonce)
+		}
+		if update.Complete {
+			return nil
+		}
+	}
+	return fmt.Errorf("threshold not reached with the stored keys")
+}
+
+// cancelRekey cancels the rekey in progress, keeping the previous keys valid, and returns err
+func cancelRekey(client *vault.Client, err error) error {
+	if cancelErr := client.Sys().RekeyCancel(); cancelErr != nil {
+		return fmt.Errorf("%s. Cannot cancel the rekey - %s", err.Error(), cancelErr.Error())
+	}
+	log.Warn("Rekey: Cancelled. The previous Unseal Keys remain valid")
+	return err
+}
+
+// deleteRekeyBackup deletes the backup of the previous keys once they are back in use and returns err.
+// A backup that can't be deleted is reported with its name
+func deleteRekeyBackup(clientsetK8s *kubernetes.Clientset, backupSecret string, err error) error {
+	if deleteErr := deleteK8sSecret(clientsetK8s, backupSecret); deleteErr != nil {
+		log.Warnf("Rekey: Cannot delete the backup of the previous Unseal Keys %s - %s. Delete it once the rekey is sorted out", backupSecret, deleteErr.Error())
+		return err
+	}
+	log.Infof("Rekey: Backup %s of the previous Unseal Keys deleted", backupSecret)
+	return err
+}
+
+// confirmRekey completes a PGP rekey once the key holders verified their keys. The previous keys,
+// which then no longer unseal Vault, are removed from the unseal keys secret and the backup.
+// It refuses when the previous keys are still valid, or when their validity can't be checked
+func confirmRekey(client *vault.Client, clientsetK8s *kubernetes.Clientset) error {
+	status, err := client.Sys().RekeyStatus()
+	if err != nil {
+		return fmt.Errorf("Rekey: %s", err.Error())
+	}
+	verification, err := client.Sys().RekeyVerificationStatus()
+	if err != nil && !strings.Contains(err.Error(), "no rekey configuration found") {
+		return fmt.Errorf("Rekey: %s", err.Error())
+	}
+	if status.Started || (verification != nil && verification.Started) {
+		return fmt.Errorf("Rekey: The rekey is still in progress. Its keys must be verified before confirming")
+	}
+
+	backupSecret := vaultSecretUnseal + RekeyBackupSuffix
+	oldKeysData, err := getValuesFromK8sSecret(clientsetK8s, &backupSecret)
+	if err != nil {
+		return fmt.Errorf("Rekey: Cannot load the previous Unseal Keys from %s - %s", backupSecret, err.Error())
+	}
+
+	// A verified rekey and a cancelled one look the same once done. Only the previous keys tell them apart
+	valid, err := unsealKeysValid(client, strings.Split(*oldKeysData, ";"))
+	if err != nil {
+		return fmt.Errorf("Rekey: Cannot tell whether the key holders verified the rekey - %s. Nothing changed", err.Error())
+	}
+	if valid {
+		return fmt.Errorf("Rekey: The previous Unseal Keys are still valid, so the rekey was not verified or was cancelled. "+
+			"Nothing changed: %s still holds them and the keys of %s were never activated", vaultSecretUnseal, vaultSecretUnseal+RekeyPGPSuffix)
+	}
+
+	// The unseal keys secret still holds the previous keys, unless replaced since the rekey
+	storedKeysData, err := getValuesFromK8sSecret(clientsetK8s, &vaultSecretUnseal)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("Rekey: %s", err.Error())
+	}
+	if err == nil && *storedKeysData == *oldKeysData {
+		if err := deleteK8sSecret(clientsetK8s, vaultSecretUnseal); err != nil {
+			return fmt.Errorf("Rekey: Cannot delete %s - %s", vaultSecretUnseal, err.Error())
+		}
+		log.Warnf("Rekey: %s deleted, as it held the previous Unseal Keys. The unseal step fails until the new keys are stored in it", vaultSecretUnseal)
+	}
+	if err := deleteK8sSecret(clientsetK8s, backupSecret); err != nil {
+		return fmt.Errorf("Rekey: Cannot delete %s - %s", backupSecret, err.Error())
+	}
+	log.Info("Rekey: Confirmed. Backup of the previous Unseal Keys deleted")
+	return nil
+}
+
+// unsealKeysValid tells whether keys still unseal Vault, by submitting them to a rekey requiring
+// verification. Its new keys are never activated, as the rekey is cancelled once complete
+func unsealKeysValid(client *vault.Client, keys []string) (bool, error) {
+	status, err := client.Sys().RekeyInit(&vault.RekeyInitRequest{
+		SecretShares:        1,
+		SecretThreshold:     1,
+		RequireVerification: true,
+	})
+	if err != nil {
+		return false, err
+	}
+	nonce := status.Nonce
+	defer func() {
+		if err := client.Sys().RekeyCancel(); err != nil {
+			log.Errorf("Rekey: Cannot cancel the rekey checking the previous Unseal Keys - %s. Cancel it with 'vault operator rekey -cancel'", err.Error())
+		}
+	}()
+
+	for _, key := range keys {
+		update, err := client.Sys().RekeyUpdate(key, nonce)
+		if err != nil {
+			// Reported by Vault once the threshold is reached with keys which don't unseal it
+			if strings.Contains(err.Error(), "key verification failed") {
+				return false, nil
+			}
+			return false, err
+		}
+		if update.Nonce != nonce {
+			return false, fmt.Errorf("nonce changed from %s to %s. Another rekey may be in progress", nonce, update.Nonce)
+		}
+		if update.Complete {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("threshold not reached with the %d stored keys", len(keys))
+}
+
+func deleteK8sSecret(clientsetK8s *kubernetes.Clientset, secretName string) error {
+	err := clientsetK8s.CoreV1().Secrets(namespace).Delete(context.TODO(), secretName, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
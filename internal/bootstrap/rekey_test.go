@@ -0,0 +1,171 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeRekeyVerification serves sys/rekey/verify, completing once threshold valid keys are submitted
+type fakeRekeyVerification struct {
+	nonce     string
+	keys      map[string]bool
+	threshold int
+	// Nonce returned in the responses, to simulate another verification taking over
+	reportedNonce string
+	submitted     map[string]bool
+}
+
+func (f *fakeRekeyVerification) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut || r.URL.Path != "/v1/sys/rekey/verify" {
+		http.NotFound(w, r)
+		return
+	}
+	var body struct {
+		Key   string `json:"key"`
+		Nonce string `json:"nonce"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Nonce != f.nonce {
+		http.Error(w, `{"errors":["incorrect nonce"]}`, http.StatusBadRequest)
+		return
+	}
+	if !f.keys[body.Key] {
+		http.Error(w, `{"errors":["invalid key"]}`, http.StatusBadRequest)
+		return
+	}
+	f.submitted[body.Key] = true
+	nonce := f.nonce
+	if f.reportedNonce != "" {
+		nonce = f.reportedNonce
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"nonce": nonce, "complete": len(f.submitted) >= f.threshold})
+}
+
+func TestVerifyRekey(t *testing.T) {
+	validKeys := map[string]bool{"k1": true, "k2": true, "k3": true}
+	tests := []struct {
+		name          string
+		keys          []string
+		nonce         string
+		reportedNonce string
+		err           string
+	}{
+		{"threshold reached", []string{"k1", "k2", "k3"}, "n1", "", ""},
+		{"not enough keys", []string{"k1"}, "n1", "", "threshold not reached"},
+		{"invalid key", []string{"k1", "bad"}, "n1", "", "invalid key"},
+		{"wrong nonce", []string{"k1", "k2"}, "n2", "", "incorrect nonce"},
+		{"nonce changed", []string{"k1", "k2"}, "n1", "n3", "nonce changed from n1 to n3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeRekeyVerification{nonce: "n1", keys: validKeys, threshold: 2, reportedNonce: test.reportedNonce, submitted: map[string]bool{}}
+			client := newFakeVaultClient(t, fake)
+
+			err := verifyRekey(client, test.keys, test.nonce)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("verifyRekey: %s", err)
+				}
+				// The keys past the threshold are not submitted
+				if len(fake.submitted) != 2 {
+					t.Fatalf("expected 2 keys submitted, got %d", len(fake.submitted))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// fakeRekey serves the rekey endpoints used by unsealKeysValid. Like Vault, it reports a failed
+// key verification once threshold keys are submitted if any of them is not an unseal key
+type fakeRekey struct {
+	keys      map[string]bool
+	threshold int
+	started   bool
+	submitted []string
+	cancelled bool
+}
+
+func (f *fakeRekey) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/sys/rekey/init":
+		if f.started {
+			http.Error(w, `{"errors":["rekey already in progress"]}`, http.StatusBadRequest)
+			return
+		}
+		f.started = true
+		json.NewEncoder(w).Encode(map[string]interface{}{"nonce": "n1", "started": true})
+	case r.Method == http.MethodDelete && r.URL.Path == "/v1/sys/rekey/init":
+		f.started, f.submitted, f.cancelled = false, nil, true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/sys/rekey/update":
+		var body struct {
+			Key string `json:"key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.submitted = append(f.submitted, body.Key)
+		if len(f.submitted) < f.threshold {
+			json.NewEncoder(w).Encode(map[string]interface{}{"nonce": "n1", "complete": false})
+			return
+		}
+		for _, key := range f.submitted {
+			if !f.keys[key] {
+				f.submitted = nil
+				http.Error(w, `{"errors":["master key verification failed: cipher: message authentication failed"]}`, http.StatusBadRequest)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"nonce": "n1", "complete": true, "keys": []string{"new"}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestUnsealKeysValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  []string
+		valid bool
+		err   string
+	}{
+		{"current keys", []string{"k1", "k2"}, true, ""},
+		{"previous keys", []string{"old1", "old2"}, false, ""},
+		{"too few keys", []string{"k1"}, false, "threshold not reached"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeRekey{keys: map[string]bool{"k1": true, "k2": true, "k3": true}, threshold: 2}
+			client := newFakeVaultClient(t, fake)
+
+			valid, err := unsealKeysValid(client, test.keys)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+			} else if err != nil || valid != test.valid {
+				t.Fatalf("got %v, %v, want %v", valid, err, test.valid)
+			}
+			// The check never leaves a rekey in progress
+			if !fake.cancelled || fake.started {
+				t.Fatal("rekey not cancelled")
+			}
+		})
+	}
+
+	// A rekey already in progress can't be told apart
+	fake := &fakeRekey{started: true, threshold: 2}
+	if _, err := unsealKeysValid(newFakeVaultClient(t, fake), []string{"k1", "k2"}); err == nil {
+		t.Fatal("expected an error while another rekey is in progress")
+	}
+}
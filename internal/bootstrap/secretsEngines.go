@@ -0,0 +1,243 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Mounts created by Vault itself, which are never pruned
+var systemMounts = []string{"sys/", "cubbyhole/", "identity/"}
+
+// Types of the engines mounted by the dedicated steps. Their keys and configuration can't be
+// recovered once unmounted, so they are pruned only when listed in secretsEngines
+var protectedSecretsEngineTypes = []string{"pki", "transit", "database", "ssh", "kubernetes"}
+
+type secretsEngineConfig struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// KV version (1 or 2). Relevant only for type kv
+	Version         int               `json:"version,omitempty"`
+	Description     string            `json:"description,omitempty"`
+	DefaultLeaseTTL string            `json:"defaultLeaseTTL,omitempty"`
+	MaxLeaseTTL     string            `json:"maxLeaseTTL,omitempty"`
+	Options         map[string]string `json:"options,omitempty"`
+}
+
+func configureSecretsEngines(client *vault.Client, config *bootstrapConfig) error {
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("Secrets engines: %s", err.Error())
+	}
+	for _, engine := range config.SecretsEngines {
+		if err := mountSecretsEngine(client, mounts, engine); err != nil {
+			return fmt.Errorf("Secrets engines: %s", err.Error())
+		}
+	}
+	if config.PruneSecretsEngines {
+		if err := pruneSecretsEngines(client, mounts, managedSecretsEnginePaths(config)); err != nil {
+			return fmt.Errorf("Secrets engines: %s", err.Error())
+		}
+	}
+	log.Info("Secrets engines: Successfully configured")
+	return nil
+}
+
+// mountSecretsEngine mounts the engine if not mounted yet, otherwise tunes it when its settings drifted
+// mounts holds the current mounts, as returned by Sys().ListMounts()
+func mountSecretsEngine(client *vault.Client, mounts map[string]*vault.MountOutput, engine secretsEngineConfig) error {
+	if engine.Path == "" || engine.Type == "" {
+		return fmt.Errorf("path and type are mandatory")
+	}
+	engine, err := normalizeSecretsEngine(engine)
+	if err != nil {
+		return err
+	}
+	path := strings.Trim(engine.Path, "/")
+	options, err := secretsEngineOptions(path, engine)
+	if err != nil {
+		return err
+	}
+
+	current, ok := mounts[path+"/"]
+	if !ok {
+		if err := client.Sys().Mount(path, &vault.MountInput{
+			Type:        engine.Type,
+			Description: engine.Description,
+			Config: vault.MountConfigInput{
+				DefaultLeaseTTL: engine.DefaultLeaseTTL,
+				MaxLeaseTTL:     engine.MaxLeaseTTL,
+			},
+			Options: options,
+		}); err != nil {
+			return err
+		}
+		log.Infof("Secrets engines: %s mounted at %s", engine.Type, path)
+		return nil
+	}
+
+	if current.Type != engine.Type {
+		return fmt.Errorf("%s is already mounted with type %s instead of %s", path, current.Type, engine.Type)
+	}
+
+	tune, drift, err := secretsEngineDrift(path, current, engine, options)
+	if err != nil {
+		return err
+	}
+	if len(drift) == 0 {
+		log.Debugf("Secrets engines: %s up to date", path)
+		return nil
+	}
+	if err := client.Sys().TuneMount(path, tune); err != nil {
+		return err
+	}
+	log.Infof("Secrets engines: %s tuned", path)
+	return nil
+}
+
+// normalizeSecretsEngine turns the kv-v2 type, accepted by Vault when mounting, into kv version 2,
+// which is the type and version Vault reports for the mount
+func normalizeSecretsEngine(engine secretsEngineConfig) (secretsEngineConfig, error) {
+	if engine.Type != "kv-v2" {
+		return engine, nil
+	}
+	if engine.Version != 0 && engine.Version != 2 {
+		return engine, fmt.Errorf("%s: type kv-v2 conflicts with version %d", engine.Path, engine.Version)
+	}
+	engine.Type = "kv"
+	engine.Version = 2
+	return engine, nil
+}
+
+// secretsEngineOptions returns the mount options of the engine, including the KV version
+func secretsEngineOptions(path string, engine secretsEngineConfig) (map[string]string, error) {
+	options := map[string]string{}
+	for k, v := range engine.Options {
+		options[k] = v
+	}
+	if engine.Version != 0 {
+		if engine.Type != "kv" {
+			return nil, fmt.Errorf("%s: version is relevant only for kv", path)
+		}
+		options["version"] = strconv.Itoa(engine.Version)
+	}
+	return options, nil
+}
+
+// secretsEngineDrift compares the settings of the mounted engine with the configured ones
+// It returns the tuning to apply and the settings which drifted
+func secretsEngineDrift(path string, current *vault.MountOutput, engine secretsEngineConfig, options map[string]string) (vault.MountConfigInput, []fieldChange, error) {
+	tune := vault.MountConfigInput{}
+	var drift []fieldChange
+	if engine.Description != "" && engine.Description != current.Description {
+		tune.Description = &engine.Description
+		drift = append(drift, fieldChange{"description", current.Description, engine.Description})
+	}
+	if engine.DefaultLeaseTTL != "" {
+		seconds, err := parseTTL(engine.DefaultLeaseTTL)
+		if err != nil {
+			return tune, nil, fmt.Errorf("%s: invalid defaultLeaseTTL - %s", path, err.Error())
+		}
+		if seconds != current.Config.DefaultLeaseTTL {
+			tune.DefaultLeaseTTL = engine.DefaultLeaseTTL
+			drift = append(drift, fieldChange{"default_lease_ttl", current.Config.DefaultLeaseTTL, engine.DefaultLeaseTTL})
+		}
+	}
+	if engine.MaxLeaseTTL != "" {
+		seconds, err := parseTTL(engine.MaxLeaseTTL)
+		if err != nil {
+			return tune, nil, fmt.Errorf("%s: invalid maxLeaseTTL - %s", path, err.Error())
+		}
+		if seconds != current.Config.MaxLeaseTTL {
+			tune.MaxLeaseTTL = engine.MaxLeaseTTL
+			drift = append(drift, fieldChange{"max_lease_ttl", current.Config.MaxLeaseTTL, engine.MaxLeaseTTL})
+		}
+	}
+	for k, v := range options {
+		if current.Options[k] == v {
+			continue
+		}
+		// KV can only be upgraded from version 1 to version 2
+		if k == "version" && engine.Type == "kv" && current.Options[k] == "2" {
+			return tune, nil, fmt.Errorf("%s is a kv version 2 engine and cannot be downgraded to version %s", path, v)
+		}
+		if tune.Options == nil {
+			tune.Options = map[string]string{}
+		}
+		tune.Options[k] = v
+		drift = append(drift, fieldChange{"options." + k, current.Options[k], v})
+	}
+	return tune, drift, nil
+}
+
+// pruneSecretsEngines unmounts the engines which are not part of the configuration
+// Engines of the types mounted by the dedicated steps are kept, see protectedSecretsEngineTypes
+func pruneSecretsEngines(client *vault.Client, mounts map[string]*vault.MountOutput, managed []string) error {
+	for path, mount := range mounts {
+		if isSystemMount(path) {
+			continue
+		}
+		found := false
+		for _, m := range managed {
+			if strings.Trim(m, "/")+"/" == path {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if isProtectedSecretsEngine(mount.Type) {
+			log.Warnf("Secrets engines: %s (%s) not configured. Not unmounted, as its keys would be lost. List it in secretsEngines or unmount it manually", path, mount.Type)
+			continue
+		}
+		if err := client.Sys().Unmount(path); err != nil {
+			return err
+		}
+		log.Warnf("Secrets engines: %s (%s) not configured. Unmounted", path, mount.Type)
+	}
+	return nil
+}
+
+func isProtectedSecretsEngine(engineType string) bool {
+	for _, t := range protectedSecretsEngineTypes {
+		if engineType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func isSystemMount(path string) bool {
+	for _, m := range systemMounts {
+		if path == m {
+			return true
+		}
+	}
+	return false
+}
+
+// managedSecretsEnginePaths returns the paths of all the secrets engines managed by this tool
+// The paths of the dedicated steps are included even when the step is disabled in this run,
+// so that the engines mounted by previous runs are kept
+func managedSecretsEnginePaths(config *bootstrapConfig) []string {
+	var paths []string
+	for _, engine := range config.SecretsEngines {
+		paths = append(paths, engine.Path)
+	}
+	paths = append(paths, pkiRootPath(config.PKI))
+	if config.PKI.Intermediate.Path != "" {
+		paths = append(paths, config.PKI.Intermediate.Path)
+	}
+	paths = append(paths,
+		transitPath(config.Transit),
+		databasePath(config.Database),
+		sshPath(config.SSH),
+		k8sSecretsEnginePath(config.K8sSecretsEngine),
+		kvImportMount(config.KVImport),
+	)
+	return paths
+}
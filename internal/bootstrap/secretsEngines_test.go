@@ -0,0 +1,140 @@
+package bootstrap
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestSecretsEngineDrift(t *testing.T) {
+	current := &vault.MountOutput{
+		Type:        "kv",
+		Description: "apps",
+		Config:      vault.MountConfigOutput{DefaultLeaseTTL: 3600, MaxLeaseTTL: 0},
+		Options:     map[string]string{"version": "2"},
+	}
+	tests := []struct {
+		name   string
+		engine secretsEngineConfig
+		fields []string
+		err    string
+	}{
+		{"up to date", secretsEngineConfig{Type: "kv", Version: 2, Description: "apps", DefaultLeaseTTL: "1h"}, nil, ""},
+		{"nothing configured", secretsEngineConfig{Type: "kv"}, nil, ""},
+		{"description", secretsEngineConfig{Type: "kv", Description: "teams"}, []string{"description"}, ""},
+		{"TTLs", secretsEngineConfig{Type: "kv", DefaultLeaseTTL: "30m", MaxLeaseTTL: "24h"}, []string{"default_lease_ttl", "max_lease_ttl"}, ""},
+		{"option", secretsEngineConfig{Type: "kv", Options: map[string]string{"version": "2", "max_versions": "5"}}, []string{"options.max_versions"}, ""},
+		{"kv downgrade", secretsEngineConfig{Type: "kv", Version: 1}, nil, "cannot be downgraded"},
+		{"invalid TTL", secretsEngineConfig{Type: "kv", MaxLeaseTTL: "forever"}, nil, "invalid maxLeaseTTL"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := secretsEngineOptions("apps", test.engine)
+			if err != nil {
+				t.Fatalf("secretsEngineOptions: %s", err)
+			}
+			tune, drift, err := secretsEngineDrift("apps", current, test.engine, options)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("secretsEngineDrift: %s", err)
+			}
+			var fields []string
+			for _, field := range drift {
+				fields = append(fields, field.Field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Fatalf("got drift %v, want %v", fields, test.fields)
+			}
+			if len(drift) == 0 && !reflect.DeepEqual(tune, vault.MountConfigInput{}) {
+				t.Fatalf("tuning %+v without drift", tune)
+			}
+		})
+	}
+}
+
+func TestNormalizeSecretsEngine(t *testing.T) {
+	tests := []struct {
+		engine   secretsEngineConfig
+		expected secretsEngineConfig
+		err      bool
+	}{
+		{secretsEngineConfig{Path: "a", Type: "kv-v2"}, secretsEngineConfig{Path: "a", Type: "kv", Version: 2}, false},
+		{secretsEngineConfig{Path: "a", Type: "kv-v2", Version: 2}, secretsEngineConfig{Path: "a", Type: "kv", Version: 2}, false},
+		{secretsEngineConfig{Path: "a", Type: "kv-v2", Version: 1}, secretsEngineConfig{}, true},
+		{secretsEngineConfig{Path: "a", Type: "kv", Version: 1}, secretsEngineConfig{Path: "a", Type: "kv", Version: 1}, false},
+		{secretsEngineConfig{Path: "a", Type: "transit"}, secretsEngineConfig{Path: "a", Type: "transit"}, false},
+	}
+	for _, test := range tests {
+		engine, err := normalizeSecretsEngine(test.engine)
+		if test.err {
+			if err == nil {
+				t.Errorf("%+v: expected an error", test.engine)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(engine, test.expected) {
+			t.Errorf("%+v: got %+v, %v, want %+v", test.engine, engine, err, test.expected)
+		}
+	}
+}
+
+func TestManagedSecretsEnginePaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   bootstrapConfig
+		expected []string
+	}{
+		{"defaults", bootstrapConfig{},
+			[]string{"database", "kubernetes", "pki", "secret", "ssh", "transit"}},
+		{"configured paths", bootstrapConfig{
+			SecretsEngines:   []secretsEngineConfig{{Path: "apps", Type: "kv"}},
+			PKI:              pkiConfig{Root: pkiCAConfig{Path: "/root-ca/"}, Intermediate: pkiCAConfig{Path: "int-ca"}},
+			Transit:          transitConfig{Path: "encrypt"},
+			Database:         databaseConfig{Path: "db"},
+			SSH:              sshConfig{Path: "ssh-ca"},
+			K8sSecretsEngine: k8sSecretsEngineConfig{Path: "k8s"},
+			KVImport:         kvImportConfig{Mount: "imported/"},
+		}, []string{"apps", "db", "encrypt", "imported", "int-ca", "k8s", "root-ca", "ssh-ca"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths := managedSecretsEnginePaths(&test.config)
+			sort.Strings(paths)
+			if !reflect.DeepEqual(paths, test.expected) {
+				t.Fatalf("got %v, want %v", paths, test.expected)
+			}
+		})
+	}
+}
+
+func TestPruneSecretsEngines(t *testing.T) {
+	fake, client := newFakeMountsVault(t, map[string]*vault.MountOutput{
+		"sys/":       {Type: "system"},
+		"identity/":  {Type: "identity"},
+		"apps/":      {Type: "kv"},
+		"scratch/":   {Type: "kv"},
+		"old-pki/":   {Type: "pki"},
+		"old-cubby/": {Type: "cubbyhole"},
+	})
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pruneSecretsEngines(client, mounts, []string{"/apps/"}); err != nil {
+		t.Fatalf("pruneSecretsEngines: %s", err)
+	}
+	sort.Strings(fake.unmounted)
+	// Protected types are kept even when not configured
+	if expected := []string{"old-cubby", "scratch"}; !reflect.DeepEqual(fake.unmounted, expected) {
+		t.Fatalf("got unmounted %v, want %v", fake.unmounted, expected)
+	}
+}
@@ -0,0 +1,338 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// settings of the bootstrap, read from the `settings` section of the configuration file,
+// the environment and the command line flags, in increasing order of precedence
+type settings struct {
+	VaultAddr      string `json:"vaultAddr"`
+	ClusterMembers string `json:"clusterMembers"`
+	Namespace      string `json:"namespace"`
+	KubeContext    string `json:"kubeContext"`
+	PortForward    bool   `json:"portForward"`
+	KeyShares      int    `json:"keyShares"`
+	KeyThreshold   int    `json:"keyThreshold"`
+
+	Init           bool `json:"init"`
+	K8sSecret      bool `json:"k8sSecret"`
+	Unseal         bool `json:"unseal"`
+	TLS            bool `json:"tls"`
+	Audit          bool `json:"audit"`
+	K8sAuth        bool `json:"k8sAuth"`
+	AppRole        bool `json:"appRole"`
+	JwtAuth        bool `json:"jwtAuth"`
+	Userpass       bool `json:"userpass"`
+	CertAuth       bool `json:"certAuth"`
+	SecretsEngines bool `json:"secretsEngines"`
+	PKI            bool `json:"pki"`
+	Transit        bool `json:"transit"`
+	KVImport       bool `json:"kvImport"`
+	Database       bool `json:"database"`
+	SSH            bool `json:"ssh"`
+	K8sEngine      bool `json:"k8sSecretsEngine"`
+	Identity       bool `json:"identity"`
+
+	ServiceAccount string `json:"serviceAccount"`
+	K8sHost        string `json:"k8sHost"`
+	SecretRoot     string `json:"secretRoot"`
+	SecretUnseal   string `json:"secretUnseal"`
+
+	// The configuration file can't point to itself
+	Config         string `json:"-"`
+	State          string `json:"state"`
+	DriftConfigMap string `json:"driftConfigMap"`
+	// Allow apply to replace the audit devices whose options changed, which resets their HMAC salt
+	ReplaceAuditDevices bool `json:"replaceAuditDevices"`
+
+	PodName      string `json:"podName"`
+	PodNamespace string `json:"podNamespace"`
+	JobImage     string `json:"jobImage"`
+}
+
+// Groups of settings, each exposed as flags by the commands which use them
+const (
+	settingsVault = iota
+	settingsInit
+	settingsConfigure
+	settingsState
+	settingsDrift
+	settingsInitContainer
+)
+
+// settingSource describes where a setting is read from
+type settingSource struct {
+	env    string
+	flag   string
+	usage  string
+	groups []int
+	field  func(s *settings) interface{}
+}
+
+var settingSources = []settingSource{
+	{"VAULT_ADDR", "vault-addr", "address of the Vault service", []int{settingsVault}, func(s *settings) interface{} { return &s.VaultAddr }},
+	{"VAULT_CLUSTER_MEMBERS", "cluster-members", "comma separated addresses of the Vault cluster members", []int{settingsVault}, func(s *settings) interface{} { return &s.ClusterMembers }},
+	{"NAMESPACE", "namespace", "namespace of the Vault cluster", []int{settingsVault}, func(s *settings) interface{} { return &s.Namespace }},
+	{"VAULT_KUBE_CONTEXT", "context", "kubeconfig context, when not running in a pod", []int{settingsVault}, func(s *settings) interface{} { return &s.KubeContext }},
+	{"VAULT_PORT_FORWARD", "port-forward", "reach the Vault pods through port-forwards of the K8s API", []int{settingsVault}, func(s *settings) interface{} { return &s.PortForward }},
+	{"VAULT_SECRET_ROOT", "secret-root", "K8s secret holding the root token", []int{settingsVault}, func(s *settings) interface{} { return &s.SecretRoot }},
+	{"VAULT_SECRET_UNSEAL", "secret-unseal", "K8s secret holding the unseal keys", []int{settingsVault}, func(s *settings) interface{} { return &s.SecretUnseal }},
+
+	{"VAULT_KEY_SHARES", "key-shares", "number of unseal key shares", []int{settingsInit, settingsInitContainer}, func(s *settings) interface{} { return &s.KeyShares }},
+	{"VAULT_KEY_THRESHOLD", "key-threshold", "number of unseal key shares required to unseal", []int{settingsInit, settingsInitContainer}, func(s *settings) interface{} { return &s.KeyThreshold }},
+	{"VAULT_ENABLE_INIT", "", "", nil, func(s *settings) interface{} { return &s.Init }},
+	{"VAULT_ENABLE_K8SSECRET", "k8s-secret", "store the root token and the unseal keys in K8s secrets", []int{settingsInit}, func(s *settings) interface{} { return &s.K8sSecret }},
+	{"VAULT_ENABLE_TLS", "tls", "issue the Vault listener certificate", []int{settingsInit}, func(s *settings) interface{} { return &s.TLS }},
+
+	{"VAULT_ENABLE_UNSEAL", "", "", nil, func(s *settings) interface{} { return &s.Unseal }},
+
+	{"VAULT_BOOTSTRAP_CONFIG", "config", "configuration file", []int{settingsConfigure}, func(s *settings) interface{} { return &s.Config }},
+	{"VAULT_K8S_HOST", "k8s-host", "K8s API URL used by Vault. Defaults to the API of the pod, or https://kubernetes.default.svc out of the cluster", []int{settingsConfigure}, func(s *settings) interface{} { return &s.K8sHost }},
+	{"VAULT_ENABLE_AUDIT", "audit", "enable audit devices", []int{settingsConfigure}, func(s *settings) interface{} { return &s.Audit }},
+	{"VAULT_ENABLE_K8SAUTH", "k8s-auth", "configure K8s authentication", []int{settingsConfigure}, func(s *settings) interface{} { return &s.K8sAuth }},
+	{"VAULT_ENABLE_APPROLE", "approle", "configure AppRole authentication", []int{settingsConfigure}, func(s *settings) interface{} { return &s.AppRole }},
+	{"VAULT_ENABLE_JWTAUTH", "jwt-auth", "configure JWT authentication", []int{settingsConfigure}, func(s *settings) interface{} { return &s.JwtAuth }},
+	{"VAULT_ENABLE_USERPASS", "userpass", "configure userpass authentication", []int{settingsConfigure}, func(s *settings) interface{} { return &s.Userpass }},
+	{"VAULT_ENABLE_CERTAUTH", "cert-auth", "configure TLS certificate authentication", []int{settingsConfigure}, func(s *settings) interface{} { return &s.CertAuth }},
+	{"VAULT_ENABLE_SECRETSENGINES", "secrets-engines", "mount secrets engines", []int{settingsConfigure}, func(s *settings) interface{} { return &s.SecretsEngines }},
+	{"VAULT_ENABLE_PKI", "pki", "configure the PKI secrets engine", []int{settingsConfigure}, func(s *settings) interface{} { return &s.PKI }},
+	{"VAULT_ENABLE_TRANSIT", "transit", "configure the transit secrets engine", []int{settingsConfigure}, func(s *settings) interface{} { return &s.Transit }},
+	{"VAULT_ENABLE_KVIMPORT", "kv-import", "import K8s secrets into KV", []int{settingsConfigure}, func(s *settings) interface{} { return &s.KVImport }},
+	{"VAULT_ENABLE_DATABASE", "database", "configure the database secrets engine", []int{settingsConfigure}, func(s *settings) interface{} { return &s.Database }},
+	{"VAULT_ENABLE_SSH", "ssh", "configure the SSH secrets engine", []int{settingsConfigure}, func(s *settings) interface{} { return &s.SSH }},
+	{"VAULT_ENABLE_K8SSECRETSENGINE", "k8s-secrets-engine", "configure the K8s secrets engine", []int{settingsConfigure}, func(s *settings) interface{} { return &s.K8sEngine }},
+	{"VAULT_ENABLE_IDENTITY", "identity", "configure identity entities and groups", []int{settingsConfigure}, func(s *settings) interface{} { return &s.Identity }},
+
+	{"VAULT_BOOTSTRAP_STATE", "state", "desired state document", []int{settingsState}, func(s *settings) interface{} { return &s.State }},
+	{"VAULT_REPLACE_AUDIT_DEVICES", "replace-audit-devices", "replace the audit devices whose options changed, resetting their HMAC salt", []int{settingsState}, func(s *settings) interface{} { return &s.ReplaceAuditDevices }},
+	{"VAULT_DRIFT_CONFIGMAP", "drift-configmap", "ConfigMap recording the drift report", []int{settingsDrift}, func(s *settings) interface{} { return &s.DriftConfigMap }},
+
+	{"VAULT_K8S_POD_NAME", "pod-name", "name of the Vault pod", []int{settingsInitContainer}, func(s *settings) interface{} { return &s.PodName }},
+	{"VAULT_K8S_NAMESPACE", "pod-namespace", "namespace of the Vault pod", []int{settingsInitContainer}, func(s *settings) interface{} { return &s.PodNamespace }},
+	{"VAULT_JOB_IMAGE", "job-image", "image of the unseal Job. Defaults to the image of the init container", []int{settingsInitContainer}, func(s *settings) interface{} { return &s.JobImage }},
+	{"VAULT_SERVICE_ACCOUNT", "service-account", "service account of the unseal Job", []int{settingsInitContainer}, func(s *settings) interface{} { return &s.ServiceAccount }},
+}
+
+// Values of the flags set on the command line, by flag name
+var settingFlagValues = map[string]string{}
+
+func defaultSettings() settings {
+	s := settings{
+		VaultAddr:      DefaultVaultAddr,
+		ClusterMembers: DefaultVaultClusterMembers,
+		KeyShares:      DefaultVaultKeyShares,
+		KeyThreshold:   DefaultVaultKeyThreshold,
+		Init:           DefaultVaultInit,
+		K8sSecret:      DefaultVaultK8sSecret,
+		Unseal:         DefaultVaultUnseal,
+		TLS:            DefaultVaultTLS,
+		Audit:          DefaultVaultAudit,
+		K8sAuth:        DefaultVaultK8sAuth,
+		AppRole:        DefaultVaultAppRole,
+		JwtAuth:        DefaultVaultJwtAuth,
+		Userpass:       DefaultVaultUserpass,
+		CertAuth:       DefaultVaultCertAuth,
+		SecretsEngines: DefaultVaultSecretsEngines,
+		PKI:            DefaultVaultPKI,
+		Transit:        DefaultVaultTransit,
+		KVImport:       DefaultVaultKVImport,
+		Database:       DefaultVaultDatabase,
+		SSH:            DefaultVaultSSH,
+		K8sEngine:      DefaultVaultK8sEngine,
+		Identity:       DefaultVaultIdentity,
+		ServiceAccount: DefaultVaultServiceAccount,
+		SecretRoot:     DefaultVaultSecretRoot,
+		SecretUnseal:   DefaultVaultSecretUnseal,
+		Config:         DefaultVaultBootstrapConfig,
+		State:          DefaultVaultBootstrapState,
+		DriftConfigMap: DefaultVaultDriftConfigMap,
+	}
+	// Extract namespace: https://github.com/kubernetes/kubernetes/pull/63707
+	// Fall back to namespace of the service account if not set via Downwards API
+	if data, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		s.Namespace = strings.TrimSpace(string(data))
+	}
+	return s
+}
+
+// LoadSettings resolves the settings from the configuration file, the environment and the flags
+// set on the command line, validates them and reports all the errors at once
+func LoadSettings() error {
+	s := defaultSettings()
+	var errs []string
+
+	// The path of the configuration file itself comes only from the environment or the flags
+	for _, source := range settingSources {
+		if source.env != "VAULT_BOOTSTRAP_CONFIG" {
+			continue
+		}
+		if value, ok := lookupSetting(source); ok {
+			s.Config = value
+		}
+	}
+	config, err := loadConfig(s.Config)
+	if err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		config.Settings.Config = s.Config
+		s = *config.Settings
+	}
+
+	for _, source := range settingSources {
+		value, ok := lookupSetting(source)
+		if !ok {
+			continue
+		}
+		if err := setSetting(source.field(&s), value); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %q for %s: %s", value, settingName(source), err.Error()))
+		}
+	}
+
+	errs = append(errs, s.validate()...)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		return fmt.Errorf("Invalid configuration: %d errors", len(errs))
+	}
+	s.apply()
+	log.Debugf("Settings: %+v", s)
+	return nil
+}
+
+// lookupSetting returns the value of the flag if set on the command line, else of the environment variable
+func lookupSetting(source settingSource) (string, bool) {
+	if value, ok := settingFlagValues[source.flag]; ok && source.flag != "" {
+		return value, true
+	}
+	return os.LookupEnv(source.env)
+}
+
+func settingName(source settingSource) string {
+	if _, ok := settingFlagValues[source.flag]; ok && source.flag != "" {
+		return "--" + source.flag
+	}
+	return source.env
+}
+
+// setSetting parses value into field, which is left untouched if value is invalid
+func setSetting(field interface{}, value string) error {
+	switch typed := field.(type) {
+	case *string:
+		*typed = value
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*typed = parsed
+	case *bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*typed = parsed
+	}
+	return nil
+}
+
+func (s *settings) validate() []string {
+	var errs []string
+	if s.KeyShares < 1 {
+		errs = append(errs, fmt.Sprintf("key shares (%d) must be at least 1", s.KeyShares))
+	}
+	if s.KeyThreshold < 1 {
+		errs = append(errs, fmt.Sprintf("key threshold (%d) must be at least 1", s.KeyThreshold))
+	}
+	if s.KeyThreshold > s.KeyShares {
+		errs = append(errs, fmt.Sprintf("key threshold (%d) must not be greater than key shares (%d)", s.KeyThreshold, s.KeyShares))
+	}
+
+	urls := map[string]string{"Vault address": s.VaultAddr}
+	for i, member := range strings.Split(s.ClusterMembers, ",") {
+		urls[fmt.Sprintf("cluster member %d", i+1)] = member
+	}
+	if s.K8sHost != "" {
+		urls["K8s host"] = s.K8sHost
+	}
+	for _, name := range sortedKeys(urls) {
+		if err := validateURL(urls[name]); err != nil {
+			errs = append(errs, fmt.Sprintf("%s %q: %s", name, urls[name], err.Error()))
+		}
+	}
+
+	names := map[string]string{
+		"root token secret":  s.SecretRoot,
+		"unseal keys secret": s.SecretUnseal,
+		"drift ConfigMap":    s.DriftConfigMap,
+	}
+	for _, name := range sortedKeys(names) {
+		for _, msg := range validation.IsDNS1123Label(names[name]) {
+			errs = append(errs, fmt.Sprintf("%s %q: %s", name, names[name], msg))
+		}
+	}
+	return errs
+}
+
+func validateURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+// apply sets the package variables used by the bootstrap steps
+func (s *settings) apply() {
+	vaultAddr = s.VaultAddr
+	vaultClusterMembers = s.ClusterMembers
+	namespace = s.Namespace
+	kubeContext = s.KubeContext
+	vaultPortForward = s.PortForward
+	vaultKeyShares = s.KeyShares
+	vaultKeyThreshold = s.KeyThreshold
+	vaultK8sHost = s.K8sHost
+	vaultInit = s.Init
+	vaultK8sSecret = s.K8sSecret
+	vaultUnseal = s.Unseal
+	vaultTLS = s.TLS
+	vaultAudit = s.Audit
+	vaultK8sAuth = s.K8sAuth
+	vaultAppRole = s.AppRole
+	vaultJwtAuth = s.JwtAuth
+	vaultUserpass = s.Userpass
+	vaultCertAuth = s.CertAuth
+	vaultSecretsEngines = s.SecretsEngines
+	vaultPKI = s.PKI
+	vaultTransit = s.Transit
+	vaultKVImport = s.KVImport
+	vaultDatabase = s.Database
+	vaultSSH = s.SSH
+	vaultK8sEngine = s.K8sEngine
+	vaultIdentity = s.Identity
+	vaultServiceAccount = s.ServiceAccount
+	vaultSecretRoot = s.SecretRoot
+	vaultSecretUnseal = s.SecretUnseal
+	vaultBootstrapConfig = s.Config
+	vaultBootstrapState = s.State
+	vaultDriftConfigMap = s.DriftConfigMap
+	vaultReplaceAuditDevices = s.ReplaceAuditDevices
+	vaultPodName = s.PodName
+	vaultPodNamespace = s.PodNamespace
+	vaultJobImage = s.JobImage
+}
@@ -0,0 +1,135 @@
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// isolateSettings clears the environment variables and the flags read by LoadSettings,
+// and restores them with the package variables set by apply once the test is done
+func isolateSettings(t *testing.T) {
+	saved := map[string]string{}
+	for _, source := range settingSources {
+		if value, ok := os.LookupEnv(source.env); ok {
+			saved[source.env] = value
+			os.Unsetenv(source.env)
+		}
+	}
+	flags := settingFlagValues
+	settingFlagValues = map[string]string{}
+	previous := defaultSettings()
+	t.Cleanup(func() {
+		for _, source := range settingSources {
+			os.Unsetenv(source.env)
+		}
+		for env, value := range saved {
+			os.Setenv(env, value)
+		}
+		settingFlagValues = flags
+		previous.apply()
+	})
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSettingsEmptySection(t *testing.T) {
+	for name, content := range map[string]string{
+		"null section":  "settings:\n",
+		"empty section": "settings: {}\n",
+		"empty file":    "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			isolateSettings(t)
+			os.Setenv("VAULT_BOOTSTRAP_CONFIG", writeConfigFile(t, content))
+
+			if err := LoadSettings(); err != nil {
+				t.Fatalf("LoadSettings: %s", err)
+			}
+			if vaultAddr != DefaultVaultAddr || vaultKeyShares != DefaultVaultKeyShares || vaultSecretUnseal != DefaultVaultSecretUnseal {
+				t.Fatalf("defaults not kept: addr %s, shares %d, unseal secret %s", vaultAddr, vaultKeyShares, vaultSecretUnseal)
+			}
+		})
+	}
+}
+
+func TestLoadSettingsPrecedence(t *testing.T) {
+	isolateSettings(t)
+	os.Setenv("VAULT_BOOTSTRAP_CONFIG", writeConfigFile(t, `settings:
+  vaultAddr: https://file:8200
+  secretRoot: file-root
+  secretUnseal: file-unseal
+  keyShares: 7
+`))
+	os.Setenv("VAULT_SECRET_ROOT", "env-root")
+	os.Setenv("VAULT_SECRET_UNSEAL", "env-unseal")
+	os.Setenv("VAULT_KEY_SHARES", "6")
+	settingFlagValues["secret-unseal"] = "flag-unseal"
+
+	if err := LoadSettings(); err != nil {
+		t.Fatalf("LoadSettings: %s", err)
+	}
+	for name, got := range map[string][2]string{
+		"file":          {vaultAddr, "https://file:8200"},
+		"env over file": {vaultSecretRoot, "env-root"},
+		"flag over env": {vaultSecretUnseal, "flag-unseal"},
+		"default kept":  {vaultDriftConfigMap, DefaultVaultDriftConfigMap},
+	} {
+		if got[0] != got[1] {
+			t.Errorf("%s: got %q, want %q", name, got[0], got[1])
+		}
+	}
+	if vaultKeyShares != 6 {
+		t.Errorf("env over file: got %d key shares, want 6", vaultKeyShares)
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(s *settings)
+		errors []string
+	}{
+		{"defaults", func(s *settings) {}, nil},
+		{"threshold above shares", func(s *settings) { s.KeyShares, s.KeyThreshold = 3, 4 },
+			[]string{"key threshold (4) must not be greater than key shares (3)"}},
+		{"no shares", func(s *settings) { s.KeyShares, s.KeyThreshold = 0, 0 },
+			[]string{"key shares (0) must be at least 1", "key threshold (0) must be at least 1"}},
+		{"invalid secret name", func(s *settings) { s.SecretRoot = "Vault_Root" },
+			[]string{`root token secret "Vault_Root"`}},
+		{"invalid ConfigMap name", func(s *settings) { s.DriftConfigMap = "drift.report" },
+			[]string{`drift ConfigMap "drift.report"`}},
+		{"invalid URL scheme", func(s *settings) { s.VaultAddr = "tcp://vault:8200" },
+			[]string{`Vault address "tcp://vault:8200": scheme must be http or https`}},
+		{"invalid K8s host", func(s *settings) { s.K8sHost = "https://" },
+			[]string{`K8s host "https://": missing host`}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := defaultSettings()
+			test.modify(&s)
+			errs := s.validate()
+			if len(errs) != len(test.errors) {
+				t.Fatalf("expected %d errors, got %q", len(test.errors), errs)
+			}
+			for i, expected := range test.errors {
+				if !strings.HasPrefix(errs[i], expected) {
+					t.Errorf("error %d: got %q, want prefix %q", i, errs[i], expected)
+				}
+			}
+		})
+	}
+}
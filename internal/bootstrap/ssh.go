@@ -0,0 +1,212 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultSSHPath        = "ssh"
+	DefaultSSHConfigMap   = "vault-ssh-ca"
+	DefaultSSHRoleKeyType = "ca"
+	sshCAPrivateKeyKey    = "private_key"
+	sshCAPublicKeyKey     = "public_key"
+	sshConfigMapPublicKey = "trusted-user-ca-keys.pem"
+)
+
+type sshConfig struct {
+	Path string `json:"path"`
+	// Optional K8s secret holding the CA key pair to import (keys private_key and public_key)
+	// If not set, the signing key is generated by Vault
+	CASecret string `json:"caSecret"`
+	// Type of the generated signing key, e.g. ssh-rsa (default), ssh-ed25519
+	KeyType string `json:"keyType"`
+	KeyBits int    `json:"keyBits"`
+	// ConfigMap where the CA public key is published
+	ConfigMap string          `json:"configMap"`
+	Roles     []sshRoleConfig `json:"roles"`
+}
+
+type sshRoleConfig struct {
+	Name                  string            `json:"name"`
+	AllowUserCertificates bool              `json:"allowUserCertificates"`
+	AllowHostCertificates bool              `json:"allowHostCertificates"`
+	AllowedUsers          string            `json:"allowedUsers"`
+	DefaultUser           string            `json:"defaultUser"`
+	AllowedDomains        string            `json:"allowedDomains"`
+	AllowSubdomains       bool              `json:"allowSubdomains"`
+	AllowedExtensions     string            `json:"allowedExtensions"`
+	DefaultExtensions     map[string]string `json:"defaultExtensions"`
+	TTL                   string            `json:"ttl"`
+	MaxTTL                string            `json:"maxTTL"`
+	// e.g. rsa-sha2-256
+	AlgorithmSigner string `json:"algorithmSigner"`
+}
+
+func sshPath(config sshConfig) string {
+	if config.Path == "" {
+		return DefaultSSHPath
+	}
+	return strings.Trim(config.Path, "/")
+}
+
+func configureSSH(client *vault.Client, clientsetK8s *kubernetes.Clientset, config sshConfig) error {
+	path := sshPath(config)
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("SSH: %s", err.Error())
+	}
+	if err := mountSecretsEngine(client, mounts, secretsEngineConfig{
+		Path: path,
+		Type: "ssh",
+	}); err != nil {
+		return fmt.Errorf("SSH: %s", err.Error())
+	}
+
+	publicKey, err := configureSSHCA(client, clientsetK8s, path, config)
+	if err != nil {
+		return fmt.Errorf("SSH: %s", err.Error())
+	}
+	for _, role := range config.Roles {
+		if err := configureSSHRole(client, path, role); err != nil {
+			return fmt.Errorf("SSH: role %s: %s", role.Name, err.Error())
+		}
+	}
+
+	configMapName := config.ConfigMap
+	if configMapName == "" {
+		configMapName = DefaultSSHConfigMap
+	}
+	if err := applyK8sConfigMap(clientsetK8s, configMapName, map[string]string{
+		sshConfigMapPublicKey: publicKey + "\n",
+	}); err != nil {
+		return fmt.Errorf("SSH: Can't publish the CA public key - %s", err.Error())
+	}
+	log.Infof("SSH: CA public key published in ConfigMap %s", configMapName)
+	log.Info("SSH: Successfully configured")
+	return nil
+}
+
+// configureSSHCA generates or imports the CA signing key unless already configured and returns its public key
+// An existing signing key is never replaced
+func configureSSHCA(client *vault.Client, clientsetK8s *kubernetes.Clientset, path string, config sshConfig) (string, error) {
+	current, err := getSSHCAPublicKey(client, path)
+	if err != nil {
+		return "", err
+	}
+
+	var privateKey, publicKey string
+	if config.CASecret != "" {
+		secret, err := getK8sSecret(clientsetK8s, config.CASecret)
+		if err != nil {
+			return "", fmt.Errorf("Can't get CA secret %s - %s", config.CASecret, err.Error())
+		}
+		private, okPrivate := secret.Data[sshCAPrivateKeyKey]
+		public, okPublic := secret.Data[sshCAPublicKeyKey]
+		if !okPrivate || !okPublic {
+			return "", fmt.Errorf("K8s secret %s must contain %s and %s", config.CASecret, sshCAPrivateKeyKey, sshCAPublicKeyKey)
+		}
+		privateKey = string(private)
+		publicKey = strings.TrimSpace(string(public))
+	}
+
+	if current != "" {
+		if publicKey != "" && !equalSSHPublicKeys(current, publicKey) {
+			return "", fmt.Errorf("%s already has a CA signing key which differs from the one in K8s secret %s. Remove it manually to import the new one", path, config.CASecret)
+		}
+		log.Infof("SSH: CA signing key already configured in %s", path)
+		return current, nil
+	}
+
+	data := map[string]interface{}{}
+	if publicKey != "" {
+		data["private_key"] = privateKey
+		data["public_key"] = publicKey
+	} else {
+		data["generate_signing_key"] = true
+		if config.KeyType != "" {
+			data["key_type"] = config.KeyType
+		}
+		if config.KeyBits != 0 {
+			data["key_bits"] = config.KeyBits
+		}
+	}
+	if _, err := client.Logical().Write(path+"/config/ca", data); err != nil {
+		return "", err
+	}
+	if publicKey != "" {
+		log.Infof("SSH: CA signing key imported from K8s secret %s", config.CASecret)
+	} else {
+		log.Infof("SSH: CA signing key generated in %s", path)
+	}
+
+	if current, err = getSSHCAPublicKey(client, path); err != nil {
+		return "", err
+	}
+	if current == "" {
+		return "", fmt.Errorf("cannot read the CA public key after configuration")
+	}
+	return current, nil
+}
+
+// getSSHCAPublicKey returns the CA public key of the SSH engine at path or an empty string if not configured yet
+func getSSHCAPublicKey(client *vault.Client, path string) (string, error) {
+	resp, err := client.Logical().Read(path + "/config/ca")
+	if err != nil {
+		// Some Vault versions answer with an error instead of a 404
+		if strings.Contains(err.Error(), "keys haven't been configured yet") {
+			return "", nil
+		}
+		return "", err
+	}
+	if resp == nil || resp.Data["public_key"] == nil {
+		return "", nil
+	}
+	return strings.TrimSpace(fmt.Sprint(resp.Data["public_key"])), nil
+}
+
+// equalSSHPublicKeys compares the key type and data of two authorized_keys entries, ignoring the comment
+func equalSSHPublicKeys(a string, b string) bool {
+	fieldsA := strings.Fields(a)
+	fieldsB := strings.Fields(b)
+	if len(fieldsA) < 2 || len(fieldsB) < 2 {
+		return a == b
+	}
+	return fieldsA[0] == fieldsB[0] && fieldsA[1] == fieldsB[1]
+}
+
+func configureSSHRole(client *vault.Client, path string, role sshRoleConfig) error {
+	if role.Name == "" {
+		return fmt.Errorf("name is mandatory")
+	}
+	if !role.AllowUserCertificates && !role.AllowHostCertificates {
+		return fmt.Errorf("at least one of allowUserCertificates or allowHostCertificates must be set")
+	}
+	data := map[string]interface{}{
+		"key_type":                DefaultSSHRoleKeyType,
+		"allow_user_certificates": role.AllowUserCertificates,
+		"allow_host_certificates": role.AllowHostCertificates,
+		"allowed_users":           role.AllowedUsers,
+		"default_user":            role.DefaultUser,
+		"allowed_domains":         role.AllowedDomains,
+		"allow_subdomains":        role.AllowSubdomains,
+		"allowed_extensions":      role.AllowedExtensions,
+		"ttl":                     role.TTL,
+		"max_ttl":                 role.MaxTTL,
+	}
+	if role.DefaultExtensions != nil {
+		data["default_extensions"] = role.DefaultExtensions
+	}
+	if role.AlgorithmSigner != "" {
+		data["algorithm_signer"] = role.AlgorithmSigner
+	}
+	if _, err := client.Logical().Write(fmt.Sprintf("%s/roles/%s", path, role.Name), data); err != nil {
+		return err
+	}
+	log.Infof("SSH: Role %s configured", role.Name)
+	return nil
+}
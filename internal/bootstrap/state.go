@@ -0,0 +1,789 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Exit codes of the commands. ExitNoChanges and ExitChanges are reported by plan, apply and drift
+const (
+	ExitOK        = 0
+	ExitNoChanges = 0
+	ExitError     = 1
+	ExitChanges   = 2
+)
+
+const (
+	stateActionCreate = "create"
+	stateActionUpdate = "update"
+	stateActionDelete = "delete"
+)
+
+// Auth methods and policies created by Vault itself, which are never pruned
+var (
+	systemAuthMethods = []string{"token/"}
+	systemPolicies    = []string{"root", "default"}
+)
+
+// Placeholder for a value kept in a K8s secret, e.g. ${k8sSecret:userpass-alice/password}
+var statePlaceholder = regexp.MustCompile(`^\$\{k8sSecret:([^/}]+)/([^}]+)\}$`)
+
+// Fields holding secrets. Vault usually does not return them, so plan compares them
+// only when returned, and export replaces them with placeholders
+var sensitiveFields = []string{
+	"password", "bindpass", "client_secret", "oidc_client_secret", "jwt", "token_reviewer_jwt",
+	"service_account_jwt", "private_key", "secret_id", "secret_key", "credentials",
+}
+
+// desiredState is the complete configuration of Vault, loaded from the YAML, JSON or HCL
+// (.hcl extension) document pointed by VAULT_BOOTSTRAP_STATE. plan and apply only manage what it contains
+type desiredState struct {
+	AuditDevices []auditDeviceConfig   `json:"auditDevices,omitempty"`
+	Mounts       []secretsEngineConfig `json:"mounts,omitempty"`
+	AuthMethods  []authMethodConfig    `json:"authMethods,omitempty"`
+	// Policy name -> HCL rules
+	Policies map[string]string `json:"policies,omitempty"`
+	// Any readable Vault endpoint, e.g. auth/kubernetes/role/app
+	Roles []stateResourceConfig `json:"roles,omitempty"`
+	// Readable sys endpoints, e.g. sys/config/cors
+	Sys []stateResourceConfig `json:"sys,omitempty"`
+	// Remove the audit devices, mounts, auth methods and policies which are not part of the document
+	Prune bool `json:"prune,omitempty"`
+}
+
+type authMethodConfig struct {
+	Path string          `json:"path"`
+	Type string          `json:"type"`
+	Tune authMountConfig `json:"tune"`
+}
+
+type stateResourceConfig struct {
+	Path string `json:"path"`
+	// Only the fields set here are compared with the current values
+	Data map[string]interface{} `json:"data"`
+}
+
+// stateChange is a single difference between Vault and the desired state
+type stateChange struct {
+	Action string        `json:"action"`
+	Kind   string        `json:"kind"`
+	Path   string        `json:"path"`
+	Fields []fieldChange `json:"fields,omitempty"`
+	Note   string        `json:"note,omitempty"`
+	// nil for the changes apply doesn't make by itself
+	apply func(client *vault.Client) error
+}
+
+type fieldChange struct {
+	Field   string      `json:"field"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+type statePlan struct {
+	Changes []stateChange  `json:"changes"`
+	Summary map[string]int `json:"summary"`
+}
+
+func loadDesiredState(path string) (*desiredState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &desiredState{}
+	if strings.HasSuffix(path, ".hcl") {
+		if data, err = hclToJSON(data, state); err != nil {
+			return nil, fmt.Errorf("Invalid desired state %s: %s", path, err.Error())
+		}
+	}
+	if err := yaml.UnmarshalStrict(data, state); err != nil {
+		return nil, fmt.Errorf("Invalid desired state %s: %s", path, err.Error())
+	}
+	log.Debugf("Desired state loaded from %s", path)
+	return state, nil
+}
+
+// Plan prints the changes needed to reach the desired state and returns the exit code
+func Plan(output string) int {
+	client, state, err := prepareState(output)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	changes, err := planState(client, state)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	if err := printPlan(changes, output); err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	if len(changes) == 0 {
+		return ExitNoChanges
+	}
+	return ExitChanges
+}
+
+// Apply executes the changes needed to reach the desired state and returns the exit code
+func Apply(output string) int {
+	client, state, err := prepareState(output)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	changes, err := planState(client, state)
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	if err := printPlan(changes, output); err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	skipped := 0
+	for _, change := range changes {
+		if change.apply == nil {
+			log.Warnf("Apply: %s %s %s skipped - %s", change.Action, change.Kind, change.Path, change.Note)
+			skipped++
+			continue
+		}
+		if err := change.apply(client); err != nil {
+			log.Errorf("Apply: %s %s %s failed - %s", change.Action, change.Kind, change.Path, err.Error())
+			return ExitError
+		}
+		log.Infof("Apply: %s %s %s done", change.Action, change.Kind, change.Path)
+	}
+	if len(changes) == 0 {
+		return ExitNoChanges
+	}
+	log.Infof("Apply: %d changes applied, %d skipped", len(changes)-skipped, skipped)
+	return ExitChanges
+}
+
+// prepareState loads the desired state and returns an authenticated Vault client
+func prepareState(output string) (*vault.Client, *desiredState, error) {
+	// Keep stdout parseable
+	if output == "json" {
+		log.SetOutput(os.Stderr)
+	} else if output != "text" {
+		return nil, nil, fmt.Errorf("Invalid output %s. Must be text or json", output)
+	}
+	state, err := loadDesiredState(vaultBootstrapState)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := resolveStatePlaceholders(state); err != nil {
+		return nil, nil, err
+	}
+	client, err := newTokenClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, state, nil
+}
+
+// newTokenClient returns a client of the Vault LB using VAULT_TOKEN or,
+// if not set, the root token stored in K8s
+func newTokenClient() (*vault.Client, error) {
+	clientConfig, err := newVaultLBConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := vault.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	if client.Token() != "" {
+		return client, nil
+	}
+
+	clientsetK8s, err := newK8sClientset()
+	if err != nil {
+		return nil, fmt.Errorf("VAULT_TOKEN not set and cannot load Root Token - %s", err.Error())
+	}
+	rootToken, err := loadRootToken(clientsetK8s)
+	if err != nil {
+		return nil, fmt.Errorf("VAULT_TOKEN not set and cannot load Root Token - %s", err.Error())
+	}
+	client.SetToken(*rootToken)
+	return client, nil
+}
+
+// resolveStatePlaceholders replaces the placeholders of the roles and sys data
+// with the values of the referenced K8s secrets
+func resolveStatePlaceholders(state *desiredState) error {
+	var clientsetK8s *kubernetes.Clientset
+	lookup := func(name string, key string) (string, error) {
+		if clientsetK8s == nil {
+			var err error
+			if clientsetK8s, err = newK8sClientset(); err != nil {
+				return "", err
+			}
+		}
+		secret, err := getK8sSecret(clientsetK8s, name)
+		if err != nil {
+			return "", err
+		}
+		value, ok := secret.Data[key]
+		if !ok {
+			return "", fmt.Errorf("K8s secret %s has no key %s", name, key)
+		}
+		return string(value), nil
+	}
+	for _, resources := range [][]stateResourceConfig{state.Roles, state.Sys} {
+		for _, resource := range resources {
+			for key, value := range resource.Data {
+				resolved, err := resolvePlaceholders(value, lookup)
+				if err != nil {
+					return fmt.Errorf("%s: %s: %s", resource.Path, key, err.Error())
+				}
+				resource.Data[key] = resolved
+			}
+		}
+	}
+	return nil
+}
+
+func resolvePlaceholders(value interface{}, lookup func(name string, key string) (string, error)) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		if match := statePlaceholder.FindStringSubmatch(typed); match != nil {
+			return lookup(match[1], match[2])
+		}
+	case []interface{}:
+		for i, item := range typed {
+			resolved, err := resolvePlaceholders(item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = resolved
+		}
+	case map[string]interface{}:
+		for k, item := range typed {
+			resolved, err := resolvePlaceholders(item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			typed[k] = resolved
+		}
+	}
+	return value, nil
+}
+
+// planState compares Vault with the desired state, using only read endpoints
+// Audit devices come first, so that the other changes are audited
+func planState(client *vault.Client, state *desiredState) ([]stateChange, error) {
+	var changes []stateChange
+	for _, plan := range []func(*vault.Client, *desiredState) ([]stateChange, error){
+		planAuditDevices,
+		planMounts,
+		planAuthMethods,
+		planPolicies,
+	} {
+		c, err := plan(client, state)
+		if err != nil {
+			return nil, fmt.Errorf("Plan: %s", err.Error())
+		}
+		changes = append(changes, c...)
+	}
+	for kind, resources := range map[string][]stateResourceConfig{
+		"role": state.Roles,
+		"sys":  state.Sys,
+	} {
+		c, err := planResources(client, kind, resources)
+		if err != nil {
+			return nil, fmt.Errorf("Plan: %s", err.Error())
+		}
+		changes = append(changes, c...)
+	}
+	// Roles may depend on the mounts and auth methods above
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return stateKindOrder(changes[i].Kind) < stateKindOrder(changes[j].Kind)
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+func stateKindOrder(kind string) int {
+	for i, k := range []string{"audit", "mount", "auth", "policy", "role", "sys"} {
+		if k == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+func planAuditDevices(client *vault.Client, state *desiredState) ([]stateChange, error) {
+	audits, err := client.Sys().ListAudit()
+	if err != nil {
+		return nil, err
+	}
+	var changes []stateChange
+	desired := map[string]bool{}
+	for _, device := range state.AuditDevices {
+		device := device
+		if device.Type == "" {
+			return nil, fmt.Errorf("audit device type is mandatory")
+		}
+		path := strings.Trim(device.Path, "/")
+		if path == "" {
+			path = device.Type
+		}
+		desired[path+"/"] = true
+
+		current, ok := audits[path+"/"]
+		if !ok {
+			changes = append(changes, stateChange{
+				Action: stateActionCreate,
+				Kind:   "audit",
+				Path:   path,
+				apply: func(client *vault.Client) error {
+					_, err := enableAuditDevice(client, map[string]*vault.Audit{}, device)
+					return err
+				},
+			})
+			continue
+		}
+		if current.Type != device.Type {
+			return nil, fmt.Errorf("audit device %s is enabled with type %s instead of %s", path, current.Type, device.Type)
+		}
+		var fields []fieldChange
+		for k, v := range device.Options {
+			if current.Options[k] != v {
+				fields = append(fields, fieldChange{"options." + k, current.Options[k], v})
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		// Audit devices cannot be tuned. Replacing one resets its HMAC salt, so it is only
+		// done when explicitly allowed
+		change := stateChange{
+			Action: stateActionUpdate,
+			Kind:   "audit",
+			Path:   path,
+			Fields: fields,
+			Note:   "replacing the device resets its HMAC salt. Not applied without --replace-audit-devices",
+		}
+		if vaultReplaceAuditDevices {
+			change.Note = "the device is replaced, which resets its HMAC salt"
+			change.apply = func(client *vault.Client) error {
+				return replaceAuditDevice(client, path, device)
+			}
+		}
+		changes = append(changes, change)
+	}
+	if state.Prune {
+		for path := range audits {
+			if desired[path] {
+				continue
+			}
+			path := path
+			changes = append(changes, stateChange{
+				Action: stateActionDelete,
+				Kind:   "audit",
+				Path:   strings.TrimSuffix(path, "/"),
+				apply: func(client *vault.Client) error {
+					return client.Sys().DisableAudit(path)
+				},
+			})
+		}
+	}
+	return changes, nil
+}
+
+// replaceAuditDevice re-enables the device at path with the desired options. A temporary copy
+// is enabled first, so that Vault is never left without the device if re-enabling fails
+func replaceAuditDevice(client *vault.Client, path string, device auditDeviceConfig) error {
+	temporary := device
+	temporary.Path = path + "-replacing"
+	if _, err := enableAuditDevice(client, map[string]*vault.Audit{}, temporary); err != nil {
+		return fmt.Errorf("cannot enable the temporary device %s - %s", temporary.Path, err.Error())
+	}
+	if err := client.Sys().DisableAudit(path); err != nil {
+		return fmt.Errorf("cannot disable %s - %s. The temporary device %s is still enabled", path, err.Error(), temporary.Path)
+	}
+	device.Path = path
+	if _, err := enableAuditDevice(client, map[string]*vault.Audit{}, device); err != nil {
+		return fmt.Errorf("cannot enable %s - %s. The temporary device %s is still enabled", path, err.Error(), temporary.Path)
+	}
+	if err := client.Sys().DisableAudit(temporary.Path); err != nil {
+		return fmt.Errorf("cannot disable the temporary device %s - %s", temporary.Path, err.Error())
+	}
+	log.Warnf("Apply: audit device %s replaced. Its HMAC salt was reset", path)
+	return nil
+}
+
+func planMounts(client *vault.Client, state *desiredState) ([]stateChange, error) {
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	var changes []stateChange
+	desired := map[string]bool{}
+	for _, engine := range state.Mounts {
+		engine := engine
+		if engine.Path == "" || engine.Type == "" {
+			return nil, fmt.Errorf("mount path and type are mandatory")
+		}
+		engine, err := normalizeSecretsEngine(engine)
+		if err != nil {
+			return nil, err
+		}
+		path := strings.Trim(engine.Path, "/")
+		desired[path+"/"] = true
+		apply := func(client *vault.Client) error {
+			return mountSecretsEngine(client, mounts, engine)
+		}
+
+		current, ok := mounts[path+"/"]
+		if !ok {
+			changes = append(changes, stateChange{Action: stateActionCreate, Kind: "mount", Path: path, apply: apply})
+			continue
+		}
+		if current.Type != engine.Type {
+			return nil, fmt.Errorf("%s is mounted with type %s instead of %s", path, current.Type, engine.Type)
+		}
+		options, err := secretsEngineOptions(path, engine)
+		if err != nil {
+			return nil, err
+		}
+		_, fields, err := secretsEngineDrift(path, current, engine, options)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			changes = append(changes, stateChange{Action: stateActionUpdate, Kind: "mount", Path: path, Fields: fields, apply: apply})
+		}
+	}
+	if state.Prune {
+		for path, mount := range mounts {
+			if desired[path] || isSystemMount(path) {
+				continue
+			}
+			if isProtectedSecretsEngine(mount.Type) {
+				log.Warnf("Plan: %s (%s) not in the desired state. Not pruned, as its keys would be lost", path, mount.Type)
+				continue
+			}
+			path := path
+			changes = append(changes, stateChange{
+				Action: stateActionDelete,
+				Kind:   "mount",
+				Path:   strings.TrimSuffix(path, "/"),
+				Fields: []fieldChange{{"type", mount.Type, nil}},
+				apply: func(client *vault.Client) error {
+					return client.Sys().Unmount(path)
+				},
+			})
+		}
+	}
+	return changes, nil
+}
+
+func planAuthMethods(client *vault.Client, state *desiredState) ([]stateChange, error) {
+	auths, err := client.Sys().ListAuth()
+	if err != nil {
+		return nil, err
+	}
+	var changes []stateChange
+	desired := map[string]bool{}
+	for _, auth := range state.AuthMethods {
+		auth := auth
+		if auth.Path == "" || auth.Type == "" {
+			return nil, fmt.Errorf("auth method path and type are mandatory")
+		}
+		path := strings.Trim(auth.Path, "/")
+		desired[path+"/"] = true
+
+		current, ok := auths[path+"/"]
+		if !ok {
+			changes = append(changes, stateChange{
+				Action: stateActionCreate,
+				Kind:   "auth",
+				Path:   path,
+				apply: func(client *vault.Client) error {
+					return enableAuth(client, path+"/", auth.Type, auth.Tune)
+				},
+			})
+			continue
+		}
+		if current.Type != auth.Type {
+			return nil, fmt.Errorf("auth method %s is enabled with type %s instead of %s", path, current.Type, auth.Type)
+		}
+		tune, err := client.Logical().Read(fmt.Sprintf("sys/auth/%s/tune", path))
+		if err != nil {
+			return nil, err
+		}
+		if tune == nil {
+			return nil, fmt.Errorf("cannot read tuning of auth mount %s", path)
+		}
+		data, err := authTuneDrift(path, tune.Data, auth.Tune)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var fields []fieldChange
+		for _, key := range sortedKeys(data) {
+			fields = append(fields, fieldChange{key, tune.Data[key], data[key]})
+		}
+		changes = append(changes, stateChange{
+			Action: stateActionUpdate,
+			Kind:   "auth",
+			Path:   path,
+			Fields: fields,
+			apply: func(client *vault.Client) error {
+				return tuneAuth(client, path, auth.Tune)
+			},
+		})
+	}
+	if state.Prune {
+		for path, auth := range auths {
+			if desired[path] || containsString(systemAuthMethods, path) {
+				continue
+			}
+			path := path
+			changes = append(changes, stateChange{
+				Action: stateActionDelete,
+				Kind:   "auth",
+				Path:   strings.TrimSuffix(path, "/"),
+				Fields: []fieldChange{{"type", auth.Type, nil}},
+				apply: func(client *vault.Client) error {
+					return client.Sys().DisableAuth(path)
+				},
+			})
+		}
+	}
+	return changes, nil
+}
+
+func planPolicies(client *vault.Client, state *desiredState) ([]stateChange, error) {
+	var changes []stateChange
+	for _, name := range sortedKeys(state.Policies) {
+		name := name
+		rules := state.Policies[name]
+		current, err := client.Sys().GetPolicy(name)
+		if err != nil {
+			return nil, err
+		}
+		apply := func(client *vault.Client) error {
+			return client.Sys().PutPolicy(name, rules)
+		}
+		if current == "" {
+			changes = append(changes, stateChange{Action: stateActionCreate, Kind: "policy", Path: name, apply: apply})
+			continue
+		}
+		if strings.TrimSpace(current) != strings.TrimSpace(rules) {
+			changes = append(changes, stateChange{
+				Action: stateActionUpdate,
+				Kind:   "policy",
+				Path:   name,
+				Fields: []fieldChange{{"policy", current, rules}},
+				apply:  apply,
+			})
+		}
+	}
+	if state.Prune {
+		policies, err := client.Sys().ListPolicies()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range policies {
+			if _, ok := state.Policies[name]; ok || containsString(systemPolicies, name) {
+				continue
+			}
+			name := name
+			changes = append(changes, stateChange{
+				Action: stateActionDelete,
+				Kind:   "policy",
+				Path:   name,
+				apply: func(client *vault.Client) error {
+					return client.Sys().DeletePolicy(name)
+				},
+			})
+		}
+	}
+	return changes, nil
+}
+
+// planResources compares generic endpoints, e.g. auth roles, with the desired data
+// Only the desired fields are compared, as Vault returns the defaults of the others
+func planResources(client *vault.Client, kind string, resources []stateResourceConfig) ([]stateChange, error) {
+	var changes []stateChange
+	for _, resource := range resources {
+		resource := resource
+		if resource.Path == "" {
+			return nil, fmt.Errorf("%s path is mandatory", kind)
+		}
+		path := strings.Trim(resource.Path, "/")
+		apply := func(client *vault.Client) error {
+			_, err := client.Logical().Write(path, resource.Data)
+			return err
+		}
+		current, err := client.Logical().Read(path)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			changes = append(changes, stateChange{Action: stateActionCreate, Kind: kind, Path: path, apply: apply})
+			continue
+		}
+		var fields []fieldChange
+		for _, key := range sortedKeys(resource.Data) {
+			if _, ok := current.Data[key]; !ok && containsString(sensitiveFields, key) {
+				continue
+			}
+			if !equalStateValue(resource.Data[key], current.Data[key]) {
+				if containsString(sensitiveFields, key) {
+					// Never print secret values
+					fields = append(fields, fieldChange{key, "(sensitive)", "(sensitive)"})
+					continue
+				}
+				fields = append(fields, fieldChange{key, current.Data[key], resource.Data[key]})
+			}
+		}
+		if len(fields) > 0 {
+			changes = append(changes, stateChange{Action: stateActionUpdate, Kind: kind, Path: path, Fields: fields, apply: apply})
+		}
+	}
+	return changes, nil
+}
+
+// equalStateValue compares a value of the desired state with the one returned by Vault
+// Lists are compared regardless of the order and TTLs regardless of their unit
+func equalStateValue(desired interface{}, current interface{}) bool {
+	switch d := desired.(type) {
+	case nil:
+		return current == nil || fmt.Sprint(current) == ""
+	case []interface{}:
+		return equalStrings(toStrings(d), stateList(current))
+	case map[string]interface{}:
+		c, _ := current.(map[string]interface{})
+		for k, v := range d {
+			if !equalStateValue(v, c[k]) {
+				return false
+			}
+		}
+		return true
+	case string:
+		if _, ok := current.([]interface{}); ok {
+			return equalStrings(stateList(d), stateList(current))
+		}
+	}
+	desiredString, currentString := fmt.Sprint(desired), fmt.Sprint(current)
+	if desiredString == currentString {
+		return true
+	}
+	// TTLs are returned in seconds
+	if desiredSeconds, err := parseTTL(desiredString); err == nil {
+		if currentSeconds, err := parseTTL(currentString); err == nil {
+			return desiredSeconds == currentSeconds
+		}
+	}
+	return false
+}
+
+// stateList converts a list or a comma separated string to a slice of strings
+func stateList(value interface{}) []string {
+	if s, ok := value.(string); ok {
+		var result []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+	return toStrings(value)
+}
+
+func newStatePlan(changes []stateChange) statePlan {
+	plan := statePlan{
+		Changes: changes,
+		Summary: map[string]int{stateActionCreate: 0, stateActionUpdate: 0, stateActionDelete: 0},
+	}
+	if plan.Changes == nil {
+		plan.Changes = []stateChange{}
+	}
+	for _, change := range changes {
+		plan.Summary[change.Action]++
+	}
+	return plan
+}
+
+func printPlan(changes []stateChange, output string) error {
+	plan := newStatePlan(changes)
+	if output == "json" {
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("No changes. Vault matches the desired state")
+		return nil
+	}
+	fmt.Print(formatStateChanges(changes))
+	fmt.Printf("Plan: %d to create, %d to update, %d to delete\n", plan.Summary[stateActionCreate], plan.Summary[stateActionUpdate], plan.Summary[stateActionDelete])
+	return nil
+}
+
+// formatStateChanges returns the human readable list of changes, one line per change and per field
+func formatStateChanges(changes []stateChange) string {
+	var b strings.Builder
+	symbols := map[string]string{stateActionCreate: "+", stateActionUpdate: "~", stateActionDelete: "-"}
+	for _, change := range changes {
+		fmt.Fprintf(&b, "%s %s %s %s\n", symbols[change.Action], change.Action, change.Kind, change.Path)
+		for _, field := range change.Fields {
+			fmt.Fprintf(&b, "      %s: %s => %s\n", field.Field, formatStateValue(field.Current), formatStateValue(field.Desired))
+		}
+		if change.Note != "" {
+			fmt.Fprintf(&b, "      (%s)\n", change.Note)
+		}
+	}
+	return b.String()
+}
+
+func formatStateValue(value interface{}) string {
+	if value == nil {
+		return "(none)"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch typed := m.(type) {
+	case map[string]interface{}:
+		for k := range typed {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range typed {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
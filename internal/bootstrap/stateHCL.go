@@ -0,0 +1,113 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+)
+
+// hclToJSON converts an HCL document into JSON, so that it is decoded with the same
+// field names and checks as the YAML and JSON ones. target is the type it is decoded into
+func hclToJSON(data []byte, target interface{}) ([]byte, error) {
+	var document interface{}
+	if err := hcl.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(normalizeHCL(document, reflect.TypeOf(target)))
+}
+
+// normalizeHCL turns the HCL blocks, decoded as lists of objects, into objects where t expects
+// one. Lists of objects are kept for slices, e.g. one mounts block per mount
+func normalizeHCL(value interface{}, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return value
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := hclObject(value)
+		if !ok {
+			return value
+		}
+		result := map[string]interface{}{}
+		for key, v := range object {
+			if field, ok := jsonField(t, key); ok {
+				v = normalizeHCL(v, field.Type)
+			}
+			result[key] = v
+		}
+		return result
+	case reflect.Map:
+		object, ok := hclObject(value)
+		if !ok {
+			return value
+		}
+		result := map[string]interface{}{}
+		for key, v := range object {
+			result[key] = normalizeHCL(v, t.Elem())
+		}
+		return result
+	case reflect.Slice:
+		var items []interface{}
+		switch list := value.(type) {
+		case []map[string]interface{}:
+			for _, item := range list {
+				items = append(items, item)
+			}
+		case []interface{}:
+			items = list
+		default:
+			return value
+		}
+		result := make([]interface{}, len(items))
+		for i, item := range items {
+			result[i] = normalizeHCL(item, t.Elem())
+		}
+		return result
+	case reflect.Interface:
+		// Free-form data: nested blocks are objects
+		if object, ok := hclObject(value); ok {
+			result := map[string]interface{}{}
+			for key, v := range object {
+				result[key] = normalizeHCL(v, t)
+			}
+			return result
+		}
+		if list, ok := value.([]interface{}); ok {
+			result := make([]interface{}, len(list))
+			for i, item := range list {
+				result[i] = normalizeHCL(item, t)
+			}
+			return result
+		}
+	}
+	return value
+}
+
+// hclObject returns the object of a single HCL block
+func hclObject(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case []map[string]interface{}:
+		if len(v) == 1 {
+			return v[0], true
+		}
+	}
+	return nil, false
+}
+
+// jsonField returns the field of t named key in JSON
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if strings.Split(field.Tag.Get("json"), ",")[0] == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,154 @@
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeStateFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNormalizeHCL(t *testing.T) {
+	type inner struct {
+		Name string `json:"name"`
+	}
+	type document struct {
+		Single inner                  `json:"single"`
+		List   []inner                `json:"list"`
+		Map    map[string]inner       `json:"map"`
+		Free   map[string]interface{} `json:"free"`
+	}
+	block := func(object map[string]interface{}) []map[string]interface{} {
+		return []map[string]interface{}{object}
+	}
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"single block to object",
+			map[string]interface{}{"single": block(map[string]interface{}{"name": "a"})},
+			map[string]interface{}{"single": map[string]interface{}{"name": "a"}}},
+		{"blocks kept as list",
+			map[string]interface{}{"list": []map[string]interface{}{{"name": "a"}, {"name": "b"}}},
+			map[string]interface{}{"list": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}}}},
+		{"single block kept as list",
+			map[string]interface{}{"list": block(map[string]interface{}{"name": "a"})},
+			map[string]interface{}{"list": []interface{}{map[string]interface{}{"name": "a"}}}},
+		{"map of blocks",
+			map[string]interface{}{"map": block(map[string]interface{}{"x": block(map[string]interface{}{"name": "a"})})},
+			map[string]interface{}{"map": map[string]interface{}{"x": map[string]interface{}{"name": "a"}}}},
+		{"free-form nested block",
+			map[string]interface{}{"free": block(map[string]interface{}{"nested": block(map[string]interface{}{"k": "v"}), "list": []interface{}{"a"}})},
+			map[string]interface{}{"free": map[string]interface{}{"nested": map[string]interface{}{"k": "v"}, "list": []interface{}{"a"}}}},
+		{"unknown key left as is",
+			map[string]interface{}{"other": block(map[string]interface{}{"k": "v"})},
+			map[string]interface{}{"other": block(map[string]interface{}{"k": "v"})}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normalizeHCL(test.value, reflect.TypeOf(&document{})); !reflect.DeepEqual(got, test.expected) {
+				t.Fatalf("got %#v, want %#v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestLoadDesiredStateHCL(t *testing.T) {
+	hcl := writeStateFile(t, "state.hcl", `
+prune = true
+
+auditDevices {
+  type = "file"
+  options {
+    file_path = "/vault/audit/audit.log"
+  }
+}
+
+mounts {
+  path    = "secret"
+  type    = "kv"
+  version = 2
+}
+
+mounts {
+  path = "transit"
+  type = "transit"
+}
+
+authMethods {
+  path = "kubernetes"
+  type = "kubernetes"
+  tune {
+    maxLeaseTTL = "24h"
+  }
+}
+
+policies {
+  app = "path \"secret/data/app/*\" { capabilities = [\"read\"] }"
+}
+
+roles {
+  path = "auth/kubernetes/role/app"
+  data {
+    bound_service_account_names = ["app"]
+    token_ttl                   = 3600
+  }
+}
+`)
+	yaml := writeStateFile(t, "state.yaml", `
+prune: true
+auditDevices:
+  - type: file
+    options:
+      file_path: /vault/audit/audit.log
+mounts:
+  - path: secret
+    type: kv
+    version: 2
+  - path: transit
+    type: transit
+authMethods:
+  - path: kubernetes
+    type: kubernetes
+    tune:
+      maxLeaseTTL: 24h
+policies:
+  app: path "secret/data/app/*" { capabilities = ["read"] }
+roles:
+  - path: auth/kubernetes/role/app
+    data:
+      bound_service_account_names: [app]
+      token_ttl: 3600
+`)
+	fromHCL, err := loadDesiredState(hcl)
+	if err != nil {
+		t.Fatalf("loadDesiredState HCL: %s", err)
+	}
+	fromYAML, err := loadDesiredState(yaml)
+	if err != nil {
+		t.Fatalf("loadDesiredState YAML: %s", err)
+	}
+	if !reflect.DeepEqual(fromHCL, fromYAML) {
+		t.Fatalf("HCL and YAML documents differ:\n%+v\n%+v", fromHCL, fromYAML)
+	}
+
+	_, err = loadDesiredState(writeStateFile(t, "unknown.hcl", "mounts {\n  path = \"a\"\n  kind = \"kv\"\n}\n"))
+	if err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Fatalf("expected an unknown field error, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package bootstrap
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestEqualStateValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		desired interface{}
+		current interface{}
+		equal   bool
+	}{
+		{"same string", "app", "app", true},
+		{"different string", "app", "web", false},
+		{"number as string", 3, "3", true},
+		{"TTL units", "1h", 3600, true},
+		{"different TTL", "1h", 1800, false},
+		{"list order", []interface{}{"b", "a"}, []interface{}{"a", "b"}, true},
+		{"list content", []interface{}{"a"}, []interface{}{"a", "b"}, false},
+		{"comma separated list", "a, b", []interface{}{"b", "a"}, true},
+		{"list as comma separated string", []interface{}{"a", "b"}, "b,a", true},
+		{"nil and empty", nil, "", true},
+		{"nil and value", nil, "x", false},
+		{"map subset", map[string]interface{}{"ttl": "1m"}, map[string]interface{}{"ttl": 60, "other": true}, true},
+		{"map change", map[string]interface{}{"ttl": "1m"}, map[string]interface{}{"ttl": 30}, false},
+		{"map missing", map[string]interface{}{"ttl": "1m"}, nil, false},
+	}
+	for _, test := range tests {
+		if got := equalStateValue(test.desired, test.current); got != test.equal {
+			t.Errorf("%s: equalStateValue(%v, %v) = %v, want %v", test.name, test.desired, test.current, got, test.equal)
+		}
+	}
+}
+
+// fakeLogicalVault serves reads of fixed endpoints
+type fakeLogicalVault map[string]map[string]interface{}
+
+func (f fakeLogicalVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, ok := f[strings.TrimPrefix(r.URL.Path, "/v1/")]
+	if r.Method != http.MethodGet || !ok {
+		// Like Vault for a missing entry
+		http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		return
+	}
+	writeFakeData(w, data)
+}
+
+func TestPlanResources(t *testing.T) {
+	client := newFakeVaultClient(t, fakeLogicalVault{
+		"auth/kubernetes/role/app": {
+			"bound_service_account_names": []interface{}{"app"},
+			"token_ttl":                   3600,
+			"token_policies":              []interface{}{"app", "default"},
+		},
+		"auth/kubernetes/role/web": {
+			"bound_service_account_names": []interface{}{"web"},
+			"token_ttl":                   3600,
+		},
+		"database/config/postgres": {
+			"connection_url": "postgresql://{{username}}@db",
+		},
+	})
+	changes, err := planResources(client, "role", []stateResourceConfig{
+		{Path: "auth/kubernetes/role/app", Data: map[string]interface{}{"bound_service_account_names": "app", "token_ttl": "1h", "token_policies": []interface{}{"default", "app"}}},
+		{Path: "/auth/kubernetes/role/web/", Data: map[string]interface{}{"token_ttl": "2h"}},
+		{Path: "auth/kubernetes/role/new", Data: map[string]interface{}{"token_ttl": "1h"}},
+		{Path: "database/config/postgres", Data: map[string]interface{}{"password": "secret"}},
+	})
+	if err != nil {
+		t.Fatalf("planResources: %s", err)
+	}
+	expected := []stateChange{
+		{Action: stateActionUpdate, Kind: "role", Path: "auth/kubernetes/role/web", Fields: []fieldChange{{"token_ttl", 3600, "2h"}}},
+		{Action: stateActionCreate, Kind: "role", Path: "auth/kubernetes/role/new"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("got %d changes, want %d: %+v", len(changes), len(expected), changes)
+	}
+	for i, change := range changes {
+		if change.Action != expected[i].Action || change.Path != expected[i].Path || len(change.Fields) != len(expected[i].Fields) {
+			t.Fatalf("change %d: got %+v, want %+v", i, change, expected[i])
+		}
+		for j, field := range change.Fields {
+			if field.Field != expected[i].Fields[j].Field || formatStateValue(field.Current) != formatStateValue(expected[i].Fields[j].Current) || field.Desired != expected[i].Fields[j].Desired {
+				t.Fatalf("change %d field %d: got %+v, want %+v", i, j, field, expected[i].Fields[j])
+			}
+		}
+	}
+}
+
+func TestPlanMounts(t *testing.T) {
+	_, client := newFakeMountsVault(t, map[string]*vault.MountOutput{
+		"sys/":     {Type: "system"},
+		"apps/":    {Type: "kv", Options: map[string]string{"version": "2"}, Config: vault.MountConfigOutput{DefaultLeaseTTL: 3600}},
+		"scratch/": {Type: "kv", Options: map[string]string{"version": "1"}},
+		"pki/":     {Type: "pki"},
+	})
+	changes, err := planMounts(client, &desiredState{
+		Mounts: []secretsEngineConfig{
+			{Path: "apps", Type: "kv-v2", DefaultLeaseTTL: "2h"},
+			{Path: "teams", Type: "kv-v2"},
+		},
+		Prune: true,
+	})
+	if err != nil {
+		t.Fatalf("planMounts: %s", err)
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.Action+" "+change.Path)
+	}
+	sort.Strings(got)
+	// pki is protected and never pruned
+	if expected := []string{"create teams", "delete scratch", "update apps"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, want %v", got, expected)
+	}
+
+	if _, err := planMounts(client, &desiredState{Mounts: []secretsEngineConfig{{Path: "apps", Type: "transit"}}}); err == nil {
+		t.Fatal("expected an error for a type change")
+	}
+}
+
+func TestFormatStateChanges(t *testing.T) {
+	changes := []stateChange{
+		{Action: stateActionCreate, Kind: "mount", Path: "teams"},
+		{Action: stateActionUpdate, Kind: "role", Path: "auth/kubernetes/role/web", Fields: []fieldChange{{"token_ttl", 3600, "2h"}, {"policies", nil, []string{"web"}}}},
+		{Action: stateActionDelete, Kind: "policy", Path: "old"},
+	}
+	expected := `+ create mount teams
+~ update role auth/kubernetes/role/web
+      token_ttl: 3600 => "2h"
+      policies: (none) => ["web"]
+- delete policy old
+`
+	if got := formatStateChanges(changes); got != expected {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, expected)
+	}
+	if plan := newStatePlan(changes); !reflect.DeepEqual(plan.Summary, map[string]int{stateActionCreate: 1, stateActionUpdate: 1, stateActionDelete: 1}) {
+		t.Fatalf("got summary %v", plan.Summary)
+	}
+}
+
+func TestPlanAuditDeviceOptionsChange(t *testing.T) {
+	for _, replace := range []bool{false, true} {
+		fake, client := newFakeAuditVault(t)
+		fake.devices["file/"] = &vault.Audit{Type: "file", Path: "file/", Options: map[string]string{"file_path": "/dev/null", "format": "json"}}
+		state := &desiredState{AuditDevices: []auditDeviceConfig{{Type: "file", Options: map[string]string{"file_path": "/dev/null", "format": "jsonx"}}}}
+
+		vaultReplaceAuditDevices = replace
+		changes, err := planAuditDevices(client, state)
+		vaultReplaceAuditDevices = false
+		if err != nil {
+			t.Fatalf("planAuditDevices: %s", err)
+		}
+		if len(changes) != 1 || changes[0].Action != stateActionUpdate || len(changes[0].Fields) != 1 || changes[0].Fields[0].Field != "options.format" {
+			t.Fatalf("expected an update of options.format, got %+v", changes)
+		}
+		if !strings.Contains(changes[0].Note, "HMAC salt") {
+			t.Errorf("HMAC salt reset not reported: %q", changes[0].Note)
+		}
+		if !replace {
+			if changes[0].apply != nil {
+				t.Fatalf("device replaced without --replace-audit-devices")
+			}
+			continue
+		}
+
+		if err := changes[0].apply(client); err != nil {
+			t.Fatalf("replacing the device: %s", err)
+		}
+		if device := fake.devices["file/"]; device == nil || device.Options["format"] != "jsonx" {
+			t.Fatalf("device not replaced: %+v", fake.devices)
+		}
+		if _, ok := fake.devices["file-replacing/"]; ok || len(fake.devices) != 1 {
+			t.Fatalf("temporary device left enabled: %v", fake.devices)
+		}
+		if n := fake.enables(); n != 2 {
+			t.Fatalf("expected the temporary and the replaced devices to be enabled, got %d enables", n)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ExitUnhealthy is returned by Status when the cluster is reachable but not healthy
+const ExitUnhealthy = 2
+
+type memberStatus struct {
+	Name            string `json:"name"`
+	Address         string `json:"address"`
+	Reachable       bool   `json:"reachable"`
+	Error           string `json:"error,omitempty"`
+	HealthCode      int    `json:"healthCode,omitempty"`
+	Initialized     bool   `json:"initialized"`
+	Sealed          bool   `json:"sealed"`
+	UnsealProgress  int    `json:"unsealProgress"`
+	UnsealThreshold int    `json:"unsealThreshold"`
+	Version         string `json:"version,omitempty"`
+	HAEnabled       bool   `json:"haEnabled"`
+	HAMode          string `json:"haMode,omitempty"`
+	LeaderAddress   string `json:"leaderAddress,omitempty"`
+	// nil when the storage is not Raft or the peers can't be read
+	RaftPeer  *bool `json:"raftPeer,omitempty"`
+	RaftVoter *bool `json:"raftVoter,omitempty"`
+}
+
+type raftPeer struct {
+	NodeID  string `json:"nodeID"`
+	Address string `json:"address"`
+	Leader  bool   `json:"leader"`
+	Voter   bool   `json:"voter"`
+}
+
+type resourceStatus struct {
+	Name   string `json:"name"`
+	Type   string `json:"type,omitempty"`
+	Exists bool   `json:"exists"`
+}
+
+type clusterStatus struct {
+	Healthy     bool             `json:"healthy"`
+	Members     []memberStatus   `json:"members"`
+	RaftPeers   []raftPeer       `json:"raftPeers,omitempty"`
+	AuthMethods []resourceStatus `json:"authMethods,omitempty"`
+	Secrets     []resourceStatus `json:"secrets,omitempty"`
+	// Checks which could not be run, e.g. without token or K8s access
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Status reports the state of each cluster member, the Raft peers, the configured auth methods
+// and the key store secrets, and returns ExitOK when healthy, ExitUnhealthy otherwise
+func Status(output string) int {
+	if output != "text" && output != "json" {
+		log.Errorf("Status: Invalid output %s. Must be 'text' or 'json'", output)
+		return ExitError
+	}
+	vaultPods, err := newVaultPods()
+	if err != nil {
+		log.Error(err.Error())
+		return ExitError
+	}
+	status := getClusterStatus(vaultPods)
+
+	if output == "json" {
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			log.Error(err.Error())
+			return ExitError
+		}
+		fmt.Println(string(data))
+	} else {
+		printClusterStatus(status)
+	}
+	if !status.Healthy {
+		return ExitUnhealthy
+	}
+	return ExitOK
+}
+
+func getClusterStatus(vaultPods []vaultPod) *clusterStatus {
+	status := &clusterStatus{Healthy: true}
+	for _, pod := range vaultPods {
+		member := getMemberStatus(pod)
+		if !member.Reachable || !member.Initialized || member.Sealed {
+			status.Healthy = false
+		}
+		status.Members = append(status.Members, member)
+	}
+
+	// Raft peers and auth methods require a token
+	client, err := newTokenClient()
+	if err != nil {
+		status.Warnings = append(status.Warnings, fmt.Sprintf("Raft peers and auth methods not checked: %s", err.Error()))
+	} else {
+		if err := checkRaftPeers(client, status); err != nil {
+			status.Warnings = append(status.Warnings, fmt.Sprintf("Raft peers not checked: %s", err.Error()))
+		}
+		if err := checkAuthMethods(client, status); err != nil {
+			status.Warnings = append(status.Warnings, fmt.Sprintf("Auth methods not checked: %s", err.Error()))
+		}
+	}
+
+	if vaultK8sSecret {
+		if err := checkKeyStoreSecrets(status); err != nil {
+			status.Warnings = append(status.Warnings, fmt.Sprintf("Key store secrets not checked: %s", err.Error()))
+		}
+	}
+	return status
+}
+
+func getMemberStatus(pod vaultPod) memberStatus {
+	member := memberStatus{Name: pod.name, Address: pod.fqdn}
+
+	// sys/health encodes the state in the status code, which the Vault client turns into errors
+	resp, err := pod.httpClient.Get(pod.client.Address() + "/v1/sys/health")
+	if err != nil {
+		member.Error = err.Error()
+		return member
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	member.Reachable = true
+	member.HealthCode = resp.StatusCode
+	var health vault.HealthResponse
+	if err := json.Unmarshal(body, &health); err == nil {
+		member.Initialized = health.Initialized
+		member.Sealed = health.Sealed
+		member.Version = health.Version
+	}
+
+	sealStatus, err := pod.client.Sys().SealStatus()
+	if err != nil {
+		member.Error = err.Error()
+		return member
+	}
+	member.UnsealProgress = sealStatus.Progress
+	member.UnsealThreshold = sealStatus.T
+	if member.Version == "" {
+		member.Version = sealStatus.Version
+	}
+
+	if member.Initialized && !member.Sealed {
+		leader, err := pod.client.Sys().Leader()
+		if err != nil {
+			member.Error = err.Error()
+			return member
+		}
+		member.HAEnabled = leader.HAEnabled
+		member.LeaderAddress = leader.LeaderAddress
+		if leader.HAEnabled {
+			member.HAMode = "standby"
+			if leader.IsSelf {
+				member.HAMode = "active"
+			} else if leader.PerfStandby {
+				member.HAMode = "perf-standby"
+			}
+		}
+	}
+	return member
+}
+
+// checkRaftPeers reads the Raft configuration and flags the members which are not peers
+func checkRaftPeers(client *vault.Client, status *clusterStatus) error {
+	secret, err := client.Logical().Read("sys/storage/raft/configuration")
+	if err != nil {
+		// Other storage backends reject the request
+		if respErr, ok := err.(*vault.ResponseError); ok && (respErr.StatusCode == 400 || respErr.StatusCode == 404) {
+			return nil
+		}
+		return err
+	}
+	if secret == nil {
+		return nil
+	}
+	config, _ := secret.Data["config"].(map[string]interface{})
+	servers, _ := config["servers"].([]interface{})
+	for _, raw := range servers {
+		server, _ := raw.(map[string]interface{})
+		peer := raftPeer{
+			NodeID:  fmt.Sprint(server["node_id"]),
+			Address: fmt.Sprint(server["address"]),
+		}
+		peer.Leader, _ = server["leader"].(bool)
+		peer.Voter, _ = server["voter"].(bool)
+		status.RaftPeers = append(status.RaftPeers, peer)
+	}
+
+	for i := range status.Members {
+		member := &status.Members[i]
+		isPeer, isVoter := false, false
+		for _, peer := range status.RaftPeers {
+			// Peers are identified by the pod name as node ID or as first label of their cluster address
+			if peer.NodeID == member.Name || strings.Split(peer.Address, ".")[0] == member.Name {
+				isPeer, isVoter = true, peer.Voter
+			}
+		}
+		member.RaftPeer, member.RaftVoter = &isPeer, &isVoter
+		if !isPeer {
+			status.Healthy = false
+		}
+	}
+	return nil
+}
+
+// checkAuthMethods verifies that the auth methods enabled by the configuration steps exist
+func checkAuthMethods(client *vault.Client, status *clusterStatus) error {
+	config, err := loadConfig(vaultBootstrapConfig)
+	if err != nil {
+		return err
+	}
+	methods := managedAuthMethods(config)
+	if len(methods) == 0 {
+		return nil
+	}
+	auths, err := client.Sys().ListAuth()
+	if err != nil {
+		return err
+	}
+	for _, path := range sortedKeys(methods) {
+		auth := auths[path+"/"]
+		exists := auth != nil && auth.Type == methods[path]
+		status.AuthMethods = append(status.AuthMethods, resourceStatus{Name: path, Type: methods[path], Exists: exists})
+		if !exists {
+			status.Healthy = false
+		}
+	}
+	return nil
+}
+
+// checkKeyStoreSecrets verifies that the K8s secrets holding the root token and the unseal keys exist
+func checkKeyStoreSecrets(status *clusterStatus) error {
+	clientsetK8s, err := newK8sClientset()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{vaultSecretRoot, vaultSecretUnseal} {
+		name := name
+		_, err := getValuesFromK8sSecret(clientsetK8s, &name)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		status.Secrets = append(status.Secrets, resourceStatus{Name: name, Type: "secret", Exists: err == nil})
+		if err != nil {
+			status.Healthy = false
+		}
+	}
+	return nil
+}
+
+func printClusterStatus(status *clusterStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MEMBER\tREACHABLE\tHEALTH\tINITIALIZED\tSEALED\tUNSEAL\tVERSION\tHA MODE\tRAFT")
+	for _, m := range status.Members {
+		if !m.Reachable {
+			fmt.Fprintf(w, "%s\tfalse\t-\t-\t-\t-\t-\t-\t-\n", m.Name)
+			continue
+		}
+		unseal := "-"
+		if m.Sealed {
+			unseal = fmt.Sprintf("%d/%d", m.UnsealProgress, m.UnsealThreshold)
+		}
+		haMode := "-"
+		if m.HAMode != "" {
+			haMode = m.HAMode
+		}
+		raft := "-"
+		if m.RaftPeer != nil {
+			raft = "not a peer"
+			if *m.RaftPeer && *m.RaftVoter {
+				raft = "voter"
+			} else if *m.RaftPeer {
+				raft = "non-voter"
+			}
+		}
+		fmt.Fprintf(w, "%s\ttrue\t%d\t%t\t%t\t%s\t%s\t%s\t%s\n", m.Name, m.HealthCode, m.Initialized, m.Sealed, unseal, m.Version, haMode, raft)
+	}
+	w.Flush()
+
+	for _, m := range status.Members {
+		if m.Error != "" {
+			fmt.Printf("\n%s: %s\n", m.Name, m.Error)
+		}
+	}
+	for _, m := range status.Members {
+		if m.LeaderAddress != "" {
+			fmt.Printf("\nLeader: %s\n", m.LeaderAddress)
+			break
+		}
+	}
+
+	if len(status.AuthMethods) > 0 || len(status.Secrets) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RESOURCE\tTYPE\tEXISTS")
+		for _, auth := range status.AuthMethods {
+			fmt.Fprintf(w, "auth/%s\t%s\t%t\n", auth.Name, auth.Type, auth.Exists)
+		}
+		for _, secret := range status.Secrets {
+			fmt.Fprintf(w, "%s\t%s\t%t\n", secret.Name, secret.Type, secret.Exists)
+		}
+		w.Flush()
+	}
+
+	for _, warning := range status.Warnings {
+		fmt.Printf("\nWarning: %s\n", warning)
+	}
+	if status.Healthy {
+		fmt.Println("\nHealthy")
+	} else {
+		fmt.Println("\nUnhealthy")
+	}
+}
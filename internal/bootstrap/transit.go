@@ -0,0 +1,142 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultTransitPath    = "transit"
+	DefaultTransitKeyType = "aes256-gcm96"
+)
+
+type transitConfig struct {
+	Path string             `json:"path"`
+	Keys []transitKeyConfig `json:"keys"`
+}
+
+type transitKeyConfig struct {
+	Name string `json:"name"`
+	// aes256-gcm96 (default), chacha20-poly1305, ed25519, ecdsa-p256, rsa-2048, rsa-4096...
+	Type string `json:"type"`
+	// Exportable and allowPlaintextBackup cannot be disabled once enabled
+	Exportable           bool   `json:"exportable"`
+	AllowPlaintextBackup bool   `json:"allowPlaintextBackup"`
+	DeletionAllowed      bool   `json:"deletionAllowed"`
+	AutoRotatePeriod     string `json:"autoRotatePeriod"`
+	// Not managed if 0
+	MinDecryptionVersion int `json:"minDecryptionVersion"`
+}
+
+func transitPath(config transitConfig) string {
+	if config.Path == "" {
+		return DefaultTransitPath
+	}
+	return strings.Trim(config.Path, "/")
+}
+
+func configureTransit(client *vault.Client, config transitConfig) error {
+	path := transitPath(config)
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("Transit: %s", err.Error())
+	}
+	if err := mountSecretsEngine(client, mounts, secretsEngineConfig{
+		Path: path,
+		Type: "transit",
+	}); err != nil {
+		return fmt.Errorf("Transit: %s", err.Error())
+	}
+	for _, key := range config.Keys {
+		if err := configureTransitKey(client, path, key); err != nil {
+			return fmt.Errorf("Transit: key %s: %s", key.Name, err.Error())
+		}
+	}
+	log.Info("Transit: Successfully configured")
+	return nil
+}
+
+// configureTransitKey creates the key if missing and adjusts its configuration when it drifted
+// Existing key material is never rotated nor deleted
+func configureTransitKey(client *vault.Client, path string, key transitKeyConfig) error {
+	if key.Name == "" {
+		return fmt.Errorf("name is mandatory")
+	}
+	keyType := key.Type
+	if keyType == "" {
+		keyType = DefaultTransitKeyType
+	}
+	keyPath := fmt.Sprintf("%s/keys/%s", path, key.Name)
+
+	current, err := client.Logical().Read(keyPath)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		data := map[string]interface{}{
+			"type":                   keyType,
+			"exportable":             key.Exportable,
+			"allow_plaintext_backup": key.AllowPlaintextBackup,
+		}
+		if key.AutoRotatePeriod != "" {
+			data["auto_rotate_period"] = key.AutoRotatePeriod
+		}
+		if _, err := client.Logical().Write(keyPath, data); err != nil {
+			return err
+		}
+		log.Infof("Transit: Key %s created", key.Name)
+		if current, err = client.Logical().Read(keyPath); err != nil {
+			return err
+		}
+		if current == nil {
+			return fmt.Errorf("cannot read key after creation")
+		}
+	}
+
+	if currentType := fmt.Sprint(current.Data["type"]); currentType != keyType {
+		return fmt.Errorf("key already exists with type %s instead of %s", currentType, keyType)
+	}
+
+	data := map[string]interface{}{}
+	if current.Data["deletion_allowed"] != key.DeletionAllowed {
+		data["deletion_allowed"] = key.DeletionAllowed
+	}
+	for field, desired := range map[string]bool{
+		"exportable":             key.Exportable,
+		"allow_plaintext_backup": key.AllowPlaintextBackup,
+	} {
+		if current.Data[field] == desired {
+			continue
+		}
+		if !desired {
+			log.Warnf("Transit: Key %s has %s enabled, which cannot be disabled", key.Name, field)
+			continue
+		}
+		data[field] = desired
+	}
+	if key.AutoRotatePeriod != "" {
+		seconds, err := parseTTL(key.AutoRotatePeriod)
+		if err != nil {
+			return fmt.Errorf("invalid autoRotatePeriod - %s", err.Error())
+		}
+		if currentSeconds, _ := parseTTL(fmt.Sprint(current.Data["auto_rotate_period"])); currentSeconds != seconds {
+			data["auto_rotate_period"] = key.AutoRotatePeriod
+		}
+	}
+	if key.MinDecryptionVersion != 0 && fmt.Sprint(current.Data["min_decryption_version"]) != fmt.Sprint(key.MinDecryptionVersion) {
+		data["min_decryption_version"] = key.MinDecryptionVersion
+	}
+
+	if len(data) == 0 {
+		log.Debugf("Transit: Key %s up to date", key.Name)
+		return nil
+	}
+	if _, err := client.Logical().Write(keyPath+"/config", data); err != nil {
+		return err
+	}
+	log.Infof("Transit: Key %s configuration updated", key.Name)
+	return nil
+}
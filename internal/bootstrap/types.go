@@ -1,9 +1,15 @@
 package bootstrap
 
-import vault "github.com/hashicorp/vault/api"
+import (
+	"net/http"
+
+	vault "github.com/hashicorp/vault/api"
+)
 
 type vaultPod struct {
 	name   string
 	fqdn   string
 	client *vault.Client
+	// HTTP client of the Vault client, for requests outside of the Vault API
+	httpClient *http.Client
 }
@@ -42,19 +42,25 @@ func shamirUnseal(pod vaultPod, unsealKeys []string) {
 	out:
 	for {
 		log.Infof("%s: Starting unsealing", pod.name)
-		// Loop through the keys and unseal
-		for j := 0; j < vaultKeyThreshold; j++ {
+		// Loop through the keys until Vault is unsealed. The threshold may have changed
+		// since the init, e.g. after a rekey, so the one reported by Vault is used
+		for _, key := range unsealKeys {
 			time.Sleep(2 * time.Second)
-			sealStatus, err = pod.client.Sys().Unseal(unsealKeys[0])
+			sealStatus, err = pod.client.Sys().Unseal(key)
 			if err != nil {
 				log.Infof("%s: %s", pod.name, err.Error())
 				continue out
 			}
-			log.Infof("%s: Unseal progress %s/%s", pod.name, strconv.Itoa(sealStatus.Progress), strconv.Itoa(vaultKeyThreshold))
+			if !sealStatus.Sealed {
+				break
+			}
+			log.Infof("%s: Unseal progress %s/%s", pod.name, strconv.Itoa(sealStatus.Progress), strconv.Itoa(sealStatus.T))
 		}
 		break
 	}
-	if !sealStatus.Sealed {
-		log.Infof("%s: Vault was successfully unsealed using Shamir keys", pod.name)
+	if sealStatus == nil || sealStatus.Sealed {
+		log.Errorf("%s: Vault still sealed after submitting the %d stored Unseal Keys", pod.name, len(unsealKeys))
+		return
 	}
+	log.Infof("%s: Vault was successfully unsealed using Shamir keys", pod.name)
 }
@@ -0,0 +1,122 @@
+package bootstrap
+
+import (
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultUserpassPath           = "userpass"
+	DefaultUserpassPasswordLength = 32
+)
+
+type userpassConfig struct {
+	Path  string          `json:"path"`
+	Tune  authMountConfig `json:"tune"`
+	Users []userpassUser  `json:"users"`
+}
+
+type userpassUser struct {
+	Name     string   `json:"name"`
+	Policies []string `json:"policies"`
+	// K8s secret holding the password under the "password" key. Defaults to userpass-<name>
+	// If the secret does not exist, a random password is generated into it
+	Secret      string `json:"secret"`
+	TokenTTL    string `json:"tokenTTL"`
+	TokenMaxTTL string `json:"tokenMaxTTL"`
+	// Set the password of an existing user to the one from the K8s secret
+	ResetPassword bool `json:"resetPassword"`
+}
+
+func configureUserpass(client *vault.Client, clientsetK8s *kubernetes.Clientset, config userpassConfig) error {
+	path := config.Path
+	if path == "" {
+		path = DefaultUserpassPath
+	}
+	if err := enableAuth(client, path, "userpass", config.Tune); err != nil {
+		return fmt.Errorf("Userpass authentication: %s", err.Error())
+	}
+	for _, user := range config.Users {
+		if err := configureUserpassUser(client, clientsetK8s, path, user); err != nil {
+			return fmt.Errorf("Userpass authentication: user %s: %s", user.Name, err.Error())
+		}
+	}
+	log.Info("Userpass authentication: Successfully configured")
+	return nil
+}
+
+func configureUserpassUser(client *vault.Client, clientsetK8s *kubernetes.Clientset, path string, user userpassUser) error {
+	if user.Name == "" {
+		return fmt.Errorf("name is mandatory")
+	}
+	userPath := fmt.Sprintf("auth/%s/users/%s", path, user.Name)
+
+	existing, err := client.Logical().Read(userPath)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"token_policies": user.Policies,
+		"token_ttl":      user.TokenTTL,
+		"token_max_ttl":  user.TokenMaxTTL,
+	}
+
+	// Existing users keep their password unless a reset is requested
+	if existing == nil || user.ResetPassword {
+		password, err := getUserpassPassword(clientsetK8s, user)
+		if err != nil {
+			return err
+		}
+		data["password"] = password
+	}
+
+	if _, err := client.Logical().Write(userPath, data); err != nil {
+		return err
+	}
+	if existing == nil {
+		log.Infof("Userpass authentication: User %s created", user.Name)
+	} else if user.ResetPassword {
+		log.Infof("Userpass authentication: User %s updated and password reset", user.Name)
+	} else {
+		log.Infof("Userpass authentication: User %s updated", user.Name)
+	}
+	return nil
+}
+
+// Read the user password from its K8s secret or generate a random one into the secret
+func getUserpassPassword(clientsetK8s *kubernetes.Clientset, user userpassUser) (string, error) {
+	secretName := user.Secret
+	if secretName == "" {
+		secretName = "userpass-" + user.Name
+	}
+	secret, err := getK8sSecret(clientsetK8s, secretName)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		password, ok := secret.Data["password"]
+		if !ok || len(password) == 0 {
+			return "", fmt.Errorf("K8s secret %s has no password", secretName)
+		}
+		log.Debugf("Userpass authentication: Password for %s loaded from K8s secret %s", user.Name, secretName)
+		return string(password), nil
+	}
+
+	password, err := generatePassword(DefaultUserpassPasswordLength)
+	if err != nil {
+		return "", err
+	}
+	if err := applyK8sSecret(clientsetK8s, secretName, map[string]string{
+		"username": user.Name,
+		"password": password,
+	}); err != nil {
+		return "", err
+	}
+	log.Infof("Userpass authentication: Password for %s generated into K8s secret %s", user.Name, secretName)
+	return password, nil
+}
@@ -0,0 +1,384 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultVaultTLSSecret          = "vault-tls"
+	DefaultVaultTLSConfigMap       = "vault-tls-ca"
+	DefaultVaultTLSService         = "vault"
+	DefaultVaultTLSHeadlessService = "vault-internal"
+	DefaultVaultTLSClusterDomain   = "cluster.local"
+	DefaultVaultTLSValidity        = "8760h"
+	DefaultVaultTLSCAValidity      = "87600h"
+	DefaultVaultTLSRenewBefore     = "720h"
+)
+
+// CA pool used by the Vault clients of this tool. If nil, TLS verification is skipped
+var vaultCAPool *x509.CertPool
+
+// vaultCALoaded records that the CA was looked up in K8s, so that it is loaded only once
+var vaultCALoaded bool
+
+type vaultTLSConfig struct {
+	// kubernetes.io/tls secret mounted by the Vault listener. The CA is kept in <secret>-ca
+	Secret string `json:"secret"`
+	// ConfigMap where the CA certificate is published for the clients
+	ConfigMap       string   `json:"configMap"`
+	Service         string   `json:"service"`
+	HeadlessService string   `json:"headlessService"`
+	ClusterDomain   string   `json:"clusterDomain"`
+	ExtraSANs       []string `json:"extraSANs"`
+	Validity        string   `json:"validity"`
+	CAValidity      string   `json:"caValidity"`
+	// Certificates expiring sooner than this are renewed
+	RenewBefore string `json:"renewBefore"`
+}
+
+// configureVaultTLS issues the CA and the certificate of the Vault listener, unless
+// valid ones already exist in K8s, and makes the Vault clients verify against this CA
+func configureVaultTLS(clientsetK8s *kubernetes.Clientset, config vaultTLSConfig) error {
+	setVaultTLSDefaults(&config)
+
+	validity, err := time.ParseDuration(config.Validity)
+	if err != nil {
+		return fmt.Errorf("Vault TLS: invalid validity - %s", err.Error())
+	}
+	caValidity, err := time.ParseDuration(config.CAValidity)
+	if err != nil {
+		return fmt.Errorf("Vault TLS: invalid caValidity - %s", err.Error())
+	}
+	renewBefore, err := time.ParseDuration(config.RenewBefore)
+	if err != nil {
+		return fmt.Errorf("Vault TLS: invalid renewBefore - %s", err.Error())
+	}
+
+	caSecretName := config.Secret + "-ca"
+	caCert, caKey, err := loadK8sTLSSecret(clientsetK8s, caSecretName)
+	if err != nil {
+		return fmt.Errorf("Vault TLS: %s", err.Error())
+	}
+	caRenewed := false
+	if caCert == nil || time.Until(caCert.NotAfter) < renewBefore {
+		caCert, caKey, err = generateVaultCA(caValidity)
+		if err != nil {
+			return fmt.Errorf("Vault TLS: Can't generate CA - %s", err.Error())
+		}
+		caKeyPEM, err := encodeKeyPEM(caKey)
+		if err != nil {
+			return fmt.Errorf("Vault TLS: %s", err.Error())
+		}
+		if err := applyK8sSecretWithType(clientsetK8s, caSecretName, apiv1.SecretTypeTLS, map[string]string{
+			apiv1.TLSCertKey:       encodeCertPEM(caCert),
+			apiv1.TLSPrivateKeyKey: caKeyPEM,
+		}); err != nil {
+			return fmt.Errorf("Vault TLS: %s", err.Error())
+		}
+		caRenewed = true
+		log.Info("Vault TLS: CA generated")
+	}
+	caPEM := encodeCertPEM(caCert)
+
+	dnsNames, ipAddresses := vaultTLSSANs(config)
+	cert, _, err := loadK8sTLSSecret(clientsetK8s, config.Secret)
+	if err != nil {
+		return fmt.Errorf("Vault TLS: %s", err.Error())
+	}
+	renew := true
+	switch {
+	case cert == nil:
+		log.Info("Vault TLS: Issuing certificate")
+	case caRenewed || cert.CheckSignatureFrom(caCert) != nil:
+		log.Info("Vault TLS: CA changed. Renewing certificate")
+	case time.Until(cert.NotAfter) < renewBefore:
+		log.Infof("Vault TLS: Certificate expires on %s. Renewing certificate", cert.NotAfter)
+	case !equalStrings(cert.DNSNames, dnsNames) || !equalIPs(cert.IPAddresses, ipAddresses):
+		log.Info("Vault TLS: SANs changed. Renewing certificate")
+	default:
+		renew = false
+		log.Info("Vault TLS: Certificate up to date")
+	}
+	if renew {
+		certPEM, keyPEM, err := generateVaultCert(caCert, caKey, dnsNames, ipAddresses, validity)
+		if err != nil {
+			return fmt.Errorf("Vault TLS: Can't generate certificate - %s", err.Error())
+		}
+		if err := applyK8sSecretWithType(clientsetK8s, config.Secret, apiv1.SecretTypeTLS, map[string]string{
+			apiv1.TLSCertKey:       certPEM,
+			apiv1.TLSPrivateKeyKey: keyPEM,
+			"ca.crt":               caPEM,
+		}); err != nil {
+			return fmt.Errorf("Vault TLS: %s", err.Error())
+		}
+		log.Warn("Vault TLS: Certificate issued. Running Vault pods must be reloaded (SIGHUP) to use it")
+	}
+
+	if err := applyK8sConfigMap(clientsetK8s, config.ConfigMap, map[string]string{
+		"ca.crt": caPEM,
+	}); err != nil {
+		return fmt.Errorf("Vault TLS: %s", err.Error())
+	}
+
+	vaultCAPool = x509.NewCertPool()
+	vaultCAPool.AddCert(caCert)
+	vaultCALoaded = true
+	log.Info("Vault TLS: Successfully configured")
+	return nil
+}
+
+func setVaultTLSDefaults(config *vaultTLSConfig) {
+	setDefaultString(&config.Secret, DefaultVaultTLSSecret)
+	setDefaultString(&config.ConfigMap, DefaultVaultTLSConfigMap)
+	setDefaultString(&config.Service, DefaultVaultTLSService)
+	setDefaultString(&config.HeadlessService, DefaultVaultTLSHeadlessService)
+	setDefaultString(&config.ClusterDomain, DefaultVaultTLSClusterDomain)
+	setDefaultString(&config.Validity, DefaultVaultTLSValidity)
+	setDefaultString(&config.CAValidity, DefaultVaultTLSCAValidity)
+	setDefaultString(&config.RenewBefore, DefaultVaultTLSRenewBefore)
+}
+
+// loadVaultCAPool loads the CA issued by the TLS step, from the ConfigMap where it is published
+// or else from the CA secret. Returns nil if no CA was issued
+func loadVaultCAPool() (*x509.CertPool, error) {
+	config, err := loadConfig(vaultBootstrapConfig)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := config.TLS
+	setVaultTLSDefaults(&tlsConfig)
+	clientsetK8s, err := newK8sClientset()
+	if err != nil {
+		log.Warnf("Vault TLS: Can't look up the CA without K8s access - %s", err.Error())
+		return nil, nil
+	}
+
+	configMap, err := clientsetK8s.CoreV1().ConfigMaps(namespace).Get(context.TODO(), tlsConfig.ConfigMap, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && configMap.Data["ca.crt"] != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(configMap.Data["ca.crt"])) {
+			return nil, fmt.Errorf("invalid CA certificate in ConfigMap %s", tlsConfig.ConfigMap)
+		}
+		log.Debugf("Vault TLS: CA loaded from ConfigMap %s", tlsConfig.ConfigMap)
+		return pool, nil
+	}
+
+	caCert, _, err := loadK8sTLSSecret(clientsetK8s, tlsConfig.Secret+"-ca")
+	if err != nil || caCert == nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	log.Debugf("Vault TLS: CA loaded from secret %s-ca", tlsConfig.Secret)
+	return pool, nil
+}
+
+// configureClientTLS verifies the Vault server certificate against the CA issued by the TLS step,
+// in this run or a previous one. The verification is skipped only when no CA was issued
+func configureClientTLS(config *vault.Config) error {
+	if !vaultCALoaded {
+		pool, err := loadVaultCAPool()
+		if err != nil {
+			return fmt.Errorf("Vault TLS: Can't load the CA - %s", err.Error())
+		}
+		vaultCAPool, vaultCALoaded = pool, true
+		if pool == nil {
+			log.Warn("Vault TLS: No CA issued by the TLS step. The Vault server certificate is not verified")
+		}
+	}
+	if vaultCAPool == nil {
+		return config.ConfigureTLS(&vault.TLSConfig{
+			Insecure: true,
+		})
+	}
+	if err := config.ConfigureTLS(&vault.TLSConfig{}); err != nil {
+		return err
+	}
+	config.HttpClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = vaultCAPool
+	return nil
+}
+
+// vaultTLSSANs derives the SANs from the Vault services and from each cluster member
+func vaultTLSSANs(config vaultTLSConfig) ([]string, []net.IP) {
+	seen := map[string]bool{}
+	var dnsNames []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	addService := func(name string) {
+		add(name)
+		add(fmt.Sprintf("%s.%s", name, namespace))
+		add(fmt.Sprintf("%s.%s.svc", name, namespace))
+		add(fmt.Sprintf("%s.%s.svc.%s", name, namespace, config.ClusterDomain))
+	}
+
+	add("localhost")
+	addService(config.Service)
+	addService(config.HeadlessService)
+	for _, member := range append(strings.Split(vaultClusterMembers, ","), vaultAddr) {
+		memberURL, err := url.Parse(member)
+		if err != nil || memberURL.Hostname() == "" || net.ParseIP(memberURL.Hostname()) != nil {
+			continue
+		}
+		add(memberURL.Hostname())
+		// Pods are reached through the headless service as <pod>.<headless service>
+		labels := strings.Split(memberURL.Hostname(), ".")
+		if len(labels) > 1 && labels[1] == config.HeadlessService {
+			addService(labels[0] + "." + config.HeadlessService)
+		}
+	}
+
+	ipAddresses := []net.IP{net.ParseIP("127.0.0.1")}
+	for _, san := range config.ExtraSANs {
+		if ip := net.ParseIP(san); ip != nil {
+			ipAddresses = append(ipAddresses, ip)
+		} else {
+			add(san)
+		}
+	}
+	return dnsNames, ipAddresses
+}
+
+func generateVaultCA(validity time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "vault-bootstrap CA"},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// generateVaultCert issues the certificate of the Vault listener and returns it with its key in PEM
+func generateVaultCert(caCert *x509.Certificate, caKey crypto.Signer, dnsNames []string, ipAddresses []net.IP, validity time.Duration) (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	notAfter := time.Now().Add(validity)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		IPAddresses:  ipAddresses,
+		NotBefore:    time.Now().Add(-5 * time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return "", "", err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", "", err
+	}
+	keyPEM, err := encodeKeyPEM(key)
+	if err != nil {
+		return "", "", err
+	}
+	return encodeCertPEM(cert), keyPEM, nil
+}
+
+// loadK8sTLSSecret returns the certificate and the key of a kubernetes.io/tls secret
+// or nil if the secret does not exist
+func loadK8sTLSSecret(clientsetK8s *kubernetes.Clientset, secretName string) (*x509.Certificate, crypto.Signer, error) {
+	secret, err := getK8sSecret(clientsetK8s, secretName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	pair, err := tls.X509KeyPair(secret.Data[apiv1.TLSCertKey], secret.Data[apiv1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid K8s secret %s - %s", secretName, err.Error())
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid key in K8s secret %s", secretName)
+	}
+	return cert, key, nil
+}
+
+func encodeCertPEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func encodeKeyPEM(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func equalIPs(a []net.IP, b []net.IP) bool {
+	var aStrings, bStrings []string
+	for _, ip := range a {
+		aStrings = append(aStrings, ip.String())
+	}
+	for _, ip := range b {
+		bStrings = append(bStrings, ip.String())
+	}
+	return equalStrings(aStrings, bStrings)
+}